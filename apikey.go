@@ -0,0 +1,137 @@
+package connect
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	connectgo "github.com/bufbuild/connect-go"
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdp-go/sdpconnect"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/oauth2"
+)
+
+// APIKeyConfig Authenticates to Overmind using an Overmind API key, which is
+// exchanged for an access token by the API
+type APIKeyConfig struct {
+	// The API key to authenticate with
+	APIKey string
+	// The root URL of the API that serves the API key exchange e.g.
+	// https://api.server.test. Defaults to the `overmindAPIURL`
+	KeyExchangeURL string
+	// The account to request a NATS token for. This has the same meaning as
+	// `ClientCredentialsConfig.Account`
+	Account string
+}
+
+// APIKeyTokenClient Gets a NATS token by first exchanging an Overmind API key
+// for an access token, then using that token to retrieve a NATS token in the
+// same way as `OAuthTokenClient`
+type APIKeyTokenClient struct {
+	*OAuthTokenClient
+}
+
+// NewAPIKeyTokenClient Generates a token client that exchanges an API key for
+// an access token whenever it needs one. `overmindAPIURL` is the root URL of the
+// NATS token exchange API e.g. https://api.server.test/v1
+func NewAPIKeyTokenClient(overmindAPIURL string, keyConfig APIKeyConfig) *APIKeyTokenClient {
+	keyExchangeURL := keyConfig.KeyExchangeURL
+
+	if keyExchangeURL == "" {
+		keyExchangeURL = overmindAPIURL
+	}
+
+	httpClient := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+
+	ts := &apiKeyTokenSource{
+		apiKey: keyConfig.APIKey,
+		client: sdpconnect.NewApiKeyServiceClient(httpClient, keyExchangeURL),
+	}
+
+	return &APIKeyTokenClient{
+		OAuthTokenClient: NewOAuthTokenClientWithTokenSource(overmindAPIURL, keyConfig.Account, ts),
+	}
+}
+
+// apiKeyTokenSource An `oauth2.TokenSource` that exchanges an API key for an
+// access token
+type apiKeyTokenSource struct {
+	apiKey string
+	client sdpconnect.ApiKeyServiceClient
+
+	mutex sync.Mutex
+	token *oauth2.Token
+	// When the current token was fetched
+	fetched time.Time
+}
+
+func (a *apiKeyTokenSource) Token() (*oauth2.Token, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token != nil && !expiresWithinMargin(a.fetched, a.token.Expiry) {
+		return a.token, nil
+	}
+
+	res, err := a.client.ExchangeKeyForToken(context.Background(), connectgo.NewRequest(&sdp.ExchangeKeyForTokenRequest{
+		ApiKey: a.apiKey,
+	}))
+
+	if err != nil {
+		return nil, fmt.Errorf("exchanging API key failed: %w", err)
+	}
+
+	accessToken := res.Msg.GetAccessToken()
+
+	if accessToken == "" {
+		return nil, errors.New("API key exchange did not return an access token")
+	}
+
+	a.token = &oauth2.Token{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		Expiry:      accessTokenExpiry(accessToken),
+	}
+	a.fetched = time.Now()
+
+	return a.token, nil
+}
+
+// accessTokenExpiry Reads the expiry from the `exp` claim of a JWT access
+// token. The signature is not verified since the token is only being inspected
+// to decide when to replace it. Returns a zero time if the token isn't a JWT or
+// has no expiry
+func accessTokenExpiry(accessToken string) time.Time {
+	parts := strings.Split(accessToken, ".")
+
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Expires int64 `json:"exp"`
+	}
+
+	err = json.Unmarshal(payload, &claims)
+
+	if err != nil || claims.Expires == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Expires, 0)
+}
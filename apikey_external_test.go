@@ -0,0 +1,109 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	connectgo "github.com/bufbuild/connect-go"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdp-go/sdpconnect"
+)
+
+// apiKeyService A fake implementation of the API key exchange
+type apiKeyService struct {
+	sdpconnect.UnimplementedApiKeyServiceHandler
+
+	apiKey      string
+	accessToken string
+
+	mutex     sync.Mutex
+	exchanges int
+}
+
+func (s *apiKeyService) ExchangeKeyForToken(ctx context.Context, req *connectgo.Request[sdp.ExchangeKeyForTokenRequest]) (*connectgo.Response[sdp.ExchangeKeyForTokenResponse], error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if req.Msg.GetApiKey() != s.apiKey {
+		return nil, connectgo.NewError(connectgo.CodeUnauthenticated, errors.New("invalid API key"))
+	}
+
+	s.exchanges++
+
+	return connectgo.NewResponse(&sdp.ExchangeKeyForTokenResponse{
+		AccessToken: s.accessToken,
+	}), nil
+}
+
+func (s *apiKeyService) Exchanges() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.exchanges
+}
+
+func TestAPIKeyTokenClient(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+	accessToken := connect.NewTestAccessToken(expiry)
+
+	service := &apiKeyService{
+		apiKey:      "ovm_api_key",
+		accessToken: accessToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(sdpconnect.NewApiKeyServiceHandler(service))
+
+	keyServer := httptest.NewServer(mux)
+	t.Cleanup(keyServer.Close)
+
+	api := connecttest.StartAPI(t, nil)
+	api.AcceptAccessToken(accessToken)
+
+	t.Run("with a valid key", func(t *testing.T) {
+		c := connect.NewAPIKeyTokenClient(api.ExchangeURL, connect.APIKeyConfig{
+			APIKey:         "ovm_api_key",
+			KeyExchangeURL: keyServer.URL,
+		})
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if service.Exchanges() != 1 {
+			t.Errorf("expected the access token to be cached, got %v exchanges", service.Exchanges())
+		}
+
+		if got := c.AccessTokenExpiry(); got.Unix() != expiry.Unix() {
+			t.Errorf("expected access token expiry to be read from the token, got %v", got)
+		}
+	})
+
+	t.Run("with an invalid key", func(t *testing.T) {
+		c := connect.NewAPIKeyTokenClient(api.ExchangeURL, connect.APIKeyConfig{
+			APIKey:         "wrong",
+			KeyExchangeURL: keyServer.URL,
+		})
+
+		_, err := c.GetJWT()
+
+		if connectgo.CodeOf(err) != connectgo.CodeUnauthenticated {
+			t.Errorf("expected an unauthenticated error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package connect
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testAccessToken Creates an unsigned JWT access token with the given expiry
+func testAccessToken(expiry time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	return fmt.Sprintf(
+		"%v.%v.%v",
+		encode([]byte(`{"alg":"none"}`)),
+		encode([]byte(fmt.Sprintf(`{"exp":%v}`, expiry.Unix()))),
+		encode([]byte("signature")),
+	)
+}
+
+func TestAccessTokenExpiry(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	if got := accessTokenExpiry(testAccessToken(expiry)); got.Unix() != expiry.Unix() {
+		t.Errorf("expected expiry %v, got %v", expiry, got)
+	}
+
+	if got := accessTokenExpiry("opaque-token"); !got.IsZero() {
+		t.Errorf("expected zero expiry for an opaque token, got %v", got)
+	}
+}
@@ -2,23 +2,35 @@ package connect
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
 	overmind "github.com/overmindtech/api-client"
+	log "github.com/sirupsen/logrus"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
 const UserAgentVersion = "0.1"
 
+// DefaultAudience The audience that OAuth tokens are requested for unless
+// otherwise specified
+const DefaultAudience = "https://api.overmind.tech"
+
+// TokenExpiryMargin Tokens that will expire within this amount of time are
+// treated as though they have already expired, and are replaced. The margin is
+// capped at half of the token's lifetime so that short-lived tokens are still
+// reused
+const TokenExpiryMargin = 30 * time.Second
+
 // TokenClient Represents something that is capable of getting NATS JWT tokens
 // for a given set of NKeys
 type TokenClient interface {
@@ -29,41 +41,101 @@ type TokenClient interface {
 	Sign([]byte) ([]byte, error)
 }
 
+// Invalidator Is implemented by token clients that cache their JWT.
+// Invalidating the JWT means that the next call to `GetJWT` will get a new one
+// rather than returning the cached token. This is used when the NATS server
+// rejects the token e.g. because it has expired or been revoked
+type Invalidator interface {
+	InvalidateJWT()
+}
+
 // BasicTokenClient stores a static token and returns it when called, ignoring
 // any provided NKeys or context since it already has the token and doesn't need
 // to make any requests
 type BasicTokenClient struct {
-	staticToken string
-	staticKeys  nkeys.KeyPair
+	mutex        sync.Mutex
+	staticToken  string
+	staticSigner Signer
+	closed       bool
 }
 
-// NewBasicTokenClient Creates a new basic token client that simply returns a static token
-func NewBasicTokenClient(token string, keys nkeys.KeyPair) *BasicTokenClient {
+// NewBasicTokenClient Creates a new basic token client that simply returns a
+// static token. `signer` is usually the `nkeys.KeyPair` that the token was
+// issued for
+func NewBasicTokenClient(token string, signer Signer) *BasicTokenClient {
 	return &BasicTokenClient{
-		staticToken: token,
-		staticKeys:  keys,
+		staticToken:  token,
+		staticSigner: signer,
 	}
 }
 
 func (b *BasicTokenClient) GetJWT() (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return "", ErrTokenClientClosed
+	}
+
 	return b.staticToken, nil
 }
 
 func (b *BasicTokenClient) Sign(in []byte) ([]byte, error) {
-	return b.staticKeys.Sign(in)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return []byte{}, ErrTokenClientClosed
+	}
+
+	return b.staticSigner.Sign(in)
 }
 
 // OAuthTokenClient Gets a NATS token by first authenticating to OAuth using the
 // Client Credentials Flow, then using that token to retrieve a NATS token.
 // Nkeys are also autogenerated
 type OAuthTokenClient struct {
-	oAuthClient *clientcredentials.Config
+	// Identity The identity that is used as the user name when requesting
+	// tokens. Defaults to the hostname
+	Identity Identity
+	// Retry Controls how failed requests for NATS tokens are retried
+	Retry RetryPolicy
+	// Breaker Stops requests for NATS tokens while the API is unhealthy. While
+	// it is open the current JWT is used for as long as it is still valid
+	Breaker CircuitBreaker
+	// Verifier If set, every JWT that is received is verified against the
+	// trusted keys that it contains. Regardless of this, JWTs that weren't
+	// issued for our NKey are always rejected
+	Verifier *JWTVerifier
+	// Signer Holds the NKey that tokens are requested for. If this is nil an
+	// NKey is generated and held in memory. Set this before the client is used
+	// to keep the key in an external signer
+	Signer Signer
+
+	tokenSource oauth2.TokenSource
 	natsConfig  *overmind.Configuration
 	natsClient  *overmind.APIClient
 	account     string
 
-	jwt  string
-	keys nkeys.KeyPair
+	// Guards the JWT and signer, since nats.go gets the JWT from its
+	// reconnect goroutine while the connection handlers may invalidate it
+	mutex  sync.Mutex
+	jwt    string
+	closed bool
+	// Closed once the JWT that is being fetched has been stored. Nil unless a
+	// new JWT is being fetched
+	generating chan struct{}
+	// Whether `Signer` was generated by the client rather than set by the
+	// caller, in which case it is wiped when the client is closed
+	generatedSigner bool
+
+	// The OAuth access token that was used to get the current JWT, when it
+	// was first seen, and when it expires. Token sources usually return a
+	// cached token, so the time that it was first seen is kept until the
+	// token changes, so that its lifetime isn't underestimated
+	accessToken        string
+	accessTokenFetched time.Time
+	accessTokenExpiry  time.Time
 }
 
 // ClientCredentialsConfig Authenticates to Overmind using the Client
@@ -80,6 +152,13 @@ type ClientCredentialsConfig struct {
 	// included in the resulting token. This will be stored in the
 	// `https://api.overmind.tech/account-name` claim
 	Account string
+	// The audience to request a token for. Defaults to `DefaultAudience`
+	Audience string
+	// Scopes to request
+	Scopes []string
+	// Additional parameters to send to the token endpoint. The `audience`
+	// parameter is always set from `Audience`
+	EndpointParams url.Values
 }
 
 // NewOAuthTokenClient Generates a token client that authenticates to OAuth
@@ -92,19 +171,47 @@ type ClientCredentialsConfig struct {
 // Tokens will be for the org specified under `org`. Note that the client must
 // have admin rights for this
 func NewOAuthTokenClient(oAuthTokenURL string, overmindAPIURL string, flowConfig ClientCredentialsConfig) *OAuthTokenClient {
+	endpointParams := url.Values{}
+
+	for key, values := range flowConfig.EndpointParams {
+		endpointParams[key] = append([]string{}, values...)
+	}
+
+	if flowConfig.Audience != "" {
+		endpointParams.Set("audience", flowConfig.Audience)
+	} else {
+		endpointParams.Set("audience", DefaultAudience)
+	}
+
 	conf := &clientcredentials.Config{
-		ClientID:     flowConfig.ClientID,
-		ClientSecret: flowConfig.ClientSecret,
-		TokenURL:     oAuthTokenURL,
-		EndpointParams: url.Values{
-			"audience": []string{"https://api.overmind.tech"},
-		},
+		ClientID:       flowConfig.ClientID,
+		ClientSecret:   flowConfig.ClientSecret,
+		TokenURL:       oAuthTokenURL,
+		Scopes:         flowConfig.Scopes,
+		EndpointParams: endpointParams,
 	}
 
-	// Get an authenticated client that we can then make more HTTP calls with
-	authenticatedClient := conf.Client(context.Background())
-	// inject otelhttp propagation
-	authenticatedClient.Transport = otelhttp.NewTransport(authenticatedClient.Transport)
+	return NewOAuthTokenClientWithTokenSource(overmindAPIURL, flowConfig.Account, conf.TokenSource(context.Background()))
+}
+
+// NewOAuthTokenClientWithTokenSource Generates a token client that uses the
+// supplied OAuth token source to authenticate to the Overmind API, then uses
+// that auth to get a NATS token. This allows any OAuth flow to be used to
+// authenticate, as long as it can produce an `oauth2.TokenSource`.
+// `overmindAPIURL` is the root URL of the NATS token exchange API and `account`
+// has the same meaning as `ClientCredentialsConfig.Account`.
+//
+// The token source is responsible for caching its own tokens, as it will be
+// called for every request
+func NewOAuthTokenClientWithTokenSource(overmindAPIURL string, account string, ts oauth2.TokenSource) *OAuthTokenClient {
+	// Get an authenticated client that we can then make more HTTP calls with,
+	// with otelhttp propagation injected
+	authenticatedClient := &http.Client{
+		Transport: otelhttp.NewTransport(&oauth2.Transport{
+			Source: ts,
+			Base:   http.DefaultTransport,
+		}),
+	}
 
 	// Configure the token exchange client to use the newly authenticated HTTP
 	// client among other things
@@ -125,127 +232,522 @@ func NewOAuthTokenClient(oAuthTokenURL string, overmindAPIURL string, flowConfig
 	nClient := overmind.NewAPIClient(tokenExchangeConf)
 
 	return &OAuthTokenClient{
-		oAuthClient: conf,
+		tokenSource: ts,
 		natsConfig:  tokenExchangeConf,
 		natsClient:  nClient,
-		account:     flowConfig.Account,
+		account:     account,
 	}
 }
 
-// generateKeys Generates a new set of keys for the client
+// generateKeys Generates a new set of keys for the client if it doesn't
+// already have a signer
 func (o *OAuthTokenClient) generateKeys() error {
-	var err error
+	if o.Signer != nil {
+		return nil
+	}
+
+	keys, err := nkeys.CreateUser()
 
-	o.keys, err = nkeys.CreateUser()
+	if err != nil {
+		return err
+	}
 
-	return err
+	o.Signer = keys
+	o.generatedSigner = true
+
+	return nil
 }
 
-// generateJWT Gets a new JWT from the auth API
-func (o *OAuthTokenClient) generateJWT(ctx context.Context) error {
-	// If we don't yet have keys generate them
-	if o.keys == nil {
-		err := o.generateKeys()
+// generateJWT Gets a new JWT from the auth API for `signer`'s key. This makes
+// requests and may sleep between retries, so it is called without holding the
+// mutex
+func (o *OAuthTokenClient) generateJWT(ctx context.Context, signer Signer) (string, error) {
+	pubKey, err := signer.PublicKey()
 
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return "", err
 	}
 
-	var err error
-	var pubKey string
-	var hostname string
-	var response *http.Response
+	userName, err := o.Identity.Name()
+
+	if err != nil {
+		return "", fmt.Errorf("generating user name failed: %w", err)
+	}
 
-	pubKey, err = o.keys.PublicKey()
+	// Make sure we have a current OAuth token, and keep track of when it
+	// expires
+	accessToken, err := o.oAuthToken(ctx)
 
 	if err != nil {
-		return err
+		return "", newOAuthError(err)
 	}
 
-	hostname, err = os.Hostname()
+	fetched := time.Now()
+
+	token, err := o.exchangeToken(ctx, overmind.TokenRequestData{
+		UserPubKey: pubKey,
+		UserName:   userName,
+	})
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Create the request for a NATS token
-	if o.account == "" {
-		// Use the regular API and let it determine what our org should be
-		o.jwt, response, err = o.natsClient.CoreApi.CreateToken(ctx).TokenRequestData(overmind.TokenRequestData{
-			UserPubKey: pubKey,
-			UserName:   hostname,
-		}).Execute()
+	// Make sure that the token is for our NKey, and if configured that it was
+	// issued by an account that we trust
+	if o.Verifier != nil {
+		_, err = o.Verifier.Verify(token, pubKey)
 	} else {
-		// Explicitly request an org
-		o.jwt, response, err = o.natsClient.AdminApi.AdminCreateToken(ctx, o.account).TokenRequestData(overmind.TokenRequestData{
-			UserPubKey: pubKey,
-			UserName:   hostname,
-		}).Execute()
+		_, err = verifySubject(token, pubKey)
 	}
 
 	if err != nil {
-		errString := fmt.Sprintf("getting NATS token failed: %v", err.Error())
+		return "", fmt.Errorf("verifying NATS token failed: %w", err)
+	}
 
-		if response != nil && response.Request != nil && response.Request.URL != nil {
-			errString = errString + fmt.Sprintf(". Request URL: %v", response.Request.URL.String())
-		}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
 
-		return errors.New(errString)
+	if o.closed {
+		return "", ErrTokenClientClosed
 	}
 
-	return nil
+	o.jwt = token
+
+	if accessToken.AccessToken != o.accessToken {
+		o.accessToken = accessToken.AccessToken
+		o.accessTokenFetched = fetched
+	}
+
+	o.accessTokenExpiry = accessToken.Expiry
+
+	return token, nil
 }
 
-func (o *OAuthTokenClient) GetJWT() (string, error) {
-	ctx, span := tracer.Start(context.Background(), "connect.GetJWT")
-	defer span.End()
+// exchangeToken Requests a NATS token from the API, retrying according to
+// `o.Retry` and failing fast while `o.Breaker` is open
+func (o *OAuthTokenClient) exchangeToken(ctx context.Context, data overmind.TokenRequestData) (string, error) {
+	err := o.Breaker.allow()
+
+	if err != nil {
+		return "", err
+	}
+
+	maxAttempts := o.Retry.maxAttempts()
+
+	for attempt := 1; ; attempt++ {
+		token, response, err := o.createToken(ctx, data)
+
+		if err == nil {
+			o.Breaker.success()
+			return token, nil
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the API's health
+			o.Breaker.cancelled()
+			return "", ctx.Err()
+		}
 
-	// If we don't yet have a JWT, generate one
-	if o.jwt == "" {
-		err := o.generateJWT(ctx)
+		tokenErr := newAPIError(err, response, o.account != "")
+
+		if !retryable(response) {
+			// The API is up, it just didn't like the request
+			o.Breaker.success()
+			return "", tokenErr
+		}
+
+		if attempt >= maxAttempts {
+			o.Breaker.failure()
+			return "", tokenErr
+		}
+
+		delay := o.Retry.backoff(attempt)
+
+		if after, ok := retryAfter(response); ok {
+			if after > o.Retry.maxBackoff() {
+				// Retrying sooner than the server asked would be pointless
+				o.Breaker.failure()
+				return "", fmt.Errorf("%w. Retry-After %v exceeds maximum backoff", tokenErr, after)
+			}
+
+			delay = after
+		}
+
+		log.WithFields(log.Fields{
+			"error":   err,
+			"attempt": attempt,
+			"delay":   delay.String(),
+		}).Warn("Getting NATS token failed, retrying")
+
+		err = sleepContext(ctx, delay)
 
 		if err != nil {
-			span.SetStatus(codes.Error, err.Error())
+			o.Breaker.cancelled()
 			return "", err
 		}
 	}
+}
 
-	claims, err := jwt.DecodeUserClaims(o.jwt)
+// createToken Makes a single request to the API for a NATS token
+func (o *OAuthTokenClient) createToken(ctx context.Context, data overmind.TokenRequestData) (string, *http.Response, error) {
+	if o.account == "" {
+		// Use the regular API and let it determine what our org should be
+		return o.natsClient.CoreApi.CreateToken(ctx).TokenRequestData(data).Execute()
+	}
+
+	// Explicitly request an org
+	return o.natsClient.AdminApi.AdminCreateToken(ctx, o.account).TokenRequestData(data).Execute()
+}
+
+func (o *OAuthTokenClient) GetJWT() (string, error) {
+	return o.GetJWTContext(context.Background())
+}
+
+// GetJWTContext Returns a NATS token, getting a new one if required. The span
+// for the request is a child of any span in `ctx`, and cancelling `ctx` stops
+// any retries
+func (o *OAuthTokenClient) GetJWTContext(ctx context.Context) (string, error) {
+	ctx, span := tracer.Start(ctx, "connect.GetJWT")
+	defer span.End()
+
+	token, err := o.currentJWT(ctx)
 
 	if err != nil {
 		span.SetStatus(codes.Error, err.Error())
-		return o.jwt, err
+		return token, err
 	}
 
-	// Validate to make sure the JWT is valid. If it isn't we'll generate a new
-	// one
-	var vr jwt.ValidationResults
+	span.SetStatus(codes.Ok, "Completed")
+	return token, nil
+}
+
+// currentJWT Returns the cached JWT, getting a new one if there isn't one or
+// if it is about to expire. Only one goroutine gets a new JWT at a time and the
+// others wait for it, but the mutex isn't held while doing so, so that signing
+// and closing aren't blocked by slow requests or retries
+func (o *OAuthTokenClient) currentJWT(ctx context.Context) (string, error) {
+	for {
+		o.mutex.Lock()
+
+		if o.closed {
+			o.mutex.Unlock()
+			return "", ErrTokenClientClosed
+		}
+
+		current := o.jwt
+		usable := false
+		var claims *jwt.UserClaims
+
+		if current != "" {
+			var err error
+
+			claims, err = jwt.DecodeUserClaims(current)
+
+			if err != nil {
+				o.mutex.Unlock()
+				return current, err
+			}
+
+			// Validate to make sure the JWT is valid. If it isn't, or it or
+			// the access token used to get it is about to expire, we'll
+			// generate a new one
+			var vr jwt.ValidationResults
+
+			claims.Validate(&vr)
+
+			usable = !vr.IsBlocking(true)
 
-	claims.Validate(&vr)
+			if usable && !o.expiringSoon(claims) {
+				o.mutex.Unlock()
+				return current, nil
+			}
+		}
+
+		if generating := o.generating; generating != nil {
+			// Another goroutine is already getting a new JWT
+			o.mutex.Unlock()
+
+			select {
+			case <-generating:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
 
-	if vr.IsBlocking(true) {
-		// Regenerate the token
-		err := o.generateJWT(ctx)
+		// If we don't yet have keys generate them
+		err := o.generateKeys()
 
 		if err != nil {
-			span.SetStatus(codes.Error, err.Error())
+			o.mutex.Unlock()
 			return "", err
 		}
+
+		signer := o.Signer
+		generatedSigner := o.generatedSigner
+		done := make(chan struct{})
+		o.generating = done
+		o.mutex.Unlock()
+
+		token, err := o.generateJWT(ctx, signer)
+
+		o.mutex.Lock()
+		o.generating = nil
+		close(done)
+
+		// The client was closed while the NKey was in use, so it was left
+		// for us to wipe
+		if o.closed && generatedSigner {
+			wipe(signer)
+		}
+
+		o.mutex.Unlock()
+
+		if err != nil && usable && o.Breaker.Open() {
+			// The API is unavailable but the current token is still valid,
+			// so keep using it until it expires
+			log.WithFields(log.Fields{
+				"error":   err,
+				"expires": time.Unix(claims.Expires, 0).String(),
+			}).Warn("Could not refresh NATS token, using current token")
+
+			return current, nil
+		}
+
+		return token, err
 	}
+}
 
-	span.SetStatus(codes.Ok, "Completed")
-	return o.jwt, nil
+// OAuthToken Returns the current OAuth token. For interactive flows this can
+// be used to store the refresh token, so that the user can be logged in again
+// later using `NewRefreshTokenClient`
+func (o *OAuthTokenClient) OAuthToken() (*oauth2.Token, error) {
+	token, err := o.tokenSource.Token()
+
+	if err != nil {
+		return nil, newOAuthError(err)
+	}
+
+	return token, nil
+}
+
+// contextTokenSource Is implemented by token sources that can stop waiting
+// for a token when a context is cancelled, such as those for interactive flows
+type contextTokenSource interface {
+	TokenContext(ctx context.Context) (*oauth2.Token, error)
+}
+
+// oAuthToken Gets a token from the token source, using `ctx` if the source
+// supports it
+func (o *OAuthTokenClient) oAuthToken(ctx context.Context) (*oauth2.Token, error) {
+	if ts, ok := o.tokenSource.(contextTokenSource); ok {
+		return ts.TokenContext(ctx)
+	}
+
+	return o.tokenSource.Token()
+}
+
+// expiringSoon Returns true if either the JWT or the OAuth access token that
+// was used to get it will expire within `TokenExpiryMargin`, capped at half of
+// their lifetimes
+func (o *OAuthTokenClient) expiringSoon(claims *jwt.UserClaims) bool {
+	if claims.Expires != 0 && expiresWithinMargin(time.Unix(claims.IssuedAt, 0), time.Unix(claims.Expires, 0)) {
+		return true
+	}
+
+	return expiresWithinMargin(o.accessTokenFetched, o.accessTokenExpiry)
+}
+
+// expiresWithinMargin Returns true if an OAuth token that was fetched at
+// `issued` will expire within `TokenExpiryMargin`. Since OAuth tokens are used
+// for every request, the margin is capped at half of the token's lifetime so
+// that short-lived tokens aren't replaced every time they are used. A zero
+// expiry means that the token never expires
+func expiresWithinMargin(issued time.Time, expiry time.Time) bool {
+	if expiry.IsZero() {
+		return false
+	}
+
+	margin := TokenExpiryMargin
+
+	if lifetime := expiry.Sub(issued); lifetime/2 < margin {
+		margin = lifetime / 2
+	}
+
+	return time.Until(expiry) < margin
+}
+
+// InvalidateJWT Discards the cached JWT so that a new one is requested the
+// next time `GetJWT` is called. The NKeys are kept
+func (o *OAuthTokenClient) InvalidateJWT() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.jwt = ""
 }
 
 func (o *OAuthTokenClient) Sign(in []byte) ([]byte, error) {
-	if o.keys == nil {
-		err := o.generateKeys()
+	return o.SignContext(context.Background(), in)
+}
 
-		if err != nil {
-			return []byte{}, err
+// SignContext Signs the nonce using the client's signer. If the signer
+// supports contexts, such as `SocketSigner`, `ctx` is passed to it
+func (o *OAuthTokenClient) SignContext(ctx context.Context, in []byte) ([]byte, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return []byte{}, ErrTokenClientClosed
+	}
+
+	err := o.generateKeys()
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return signContext(ctx, o.Signer, in)
+}
+
+// interactiveTokenSource An `oauth2.TokenSource` for flows that require the
+// user to log in. The user is only asked to log in again once the current
+// token has expired and can't be refreshed
+type interactiveTokenSource struct {
+	config *oauth2.Config
+	// Runs the login flow, returning the resulting token
+	login func(ctx context.Context) (*oauth2.Token, error)
+	// If set, the token is revoked when the source is closed
+	revocationURL string
+
+	// Cancelled by `Close()` to stop a login that is in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Held while refreshing or logging in, so that the user is only asked to
+	// log in once. This is a channel rather than a mutex so that waiting for
+	// it can be cancelled
+	loggingIn chan struct{}
+
+	mutex  sync.Mutex
+	closed bool
+	// Refreshes the token once the user has logged in
+	refresher oauth2.TokenSource
+	// The most recent token
+	token *oauth2.Token
+}
+
+// newInteractiveTokenSource Creates a token source that runs `login` when the
+// user needs to log in
+func newInteractiveTokenSource(config *oauth2.Config, login func(ctx context.Context) (*oauth2.Token, error), revocationURL string) *interactiveTokenSource {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &interactiveTokenSource{
+		config:        config,
+		login:         login,
+		revocationURL: revocationURL,
+		ctx:           ctx,
+		cancel:        cancel,
+		loggingIn:     make(chan struct{}, 1),
+	}
+}
+
+func (i *interactiveTokenSource) Token() (*oauth2.Token, error) {
+	return i.TokenContext(context.Background())
+}
+
+// TokenContext Returns the current token, refreshing it or asking the user to
+// log in again if needed. Cancelling `ctx` stops waiting for the user
+func (i *interactiveTokenSource) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	select {
+	case i.loggingIn <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-i.ctx.Done():
+		return nil, ErrTokenClientClosed
+	}
+
+	defer func() { <-i.loggingIn }()
+
+	i.mutex.Lock()
+	refresher := i.refresher
+	i.mutex.Unlock()
+
+	if refresher != nil {
+		token, err := refresher.Token()
+
+		if err == nil {
+			return i.store(token, refresher)
 		}
+
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Info("Could not refresh OAuth token, logging in again")
+	}
+
+	// The login is stopped if either the caller gives up or the source is
+	// closed
+	loginCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-i.ctx.Done():
+			cancel()
+		case <-loginCtx.Done():
+		}
+	}()
+
+	token, err := i.login(loginCtx)
+
+	if err != nil {
+		if i.ctx.Err() != nil {
+			return nil, ErrTokenClientClosed
+		}
+
+		return nil, err
+	}
+
+	return i.store(token, i.config.TokenSource(context.Background(), token))
+}
+
+// store Keeps the token and the source that refreshes it, unless the source
+// has been closed in the meantime
+func (i *interactiveTokenSource) store(token *oauth2.Token, refresher oauth2.TokenSource) (*oauth2.Token, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.closed {
+		return nil, ErrTokenClientClosed
+	}
+
+	i.refresher = refresher
+	i.token = token
+
+	return token, nil
+}
+
+// Close Stops the token from being refreshed, and revokes it if there is a
+// revocation endpoint. A login that is in progress is cancelled
+func (i *interactiveTokenSource) Close() error {
+	i.mutex.Lock()
+
+	if i.closed {
+		i.mutex.Unlock()
+		return nil
+	}
+
+	token := i.token
+
+	i.closed = true
+	i.refresher = nil
+	i.token = nil
+	i.mutex.Unlock()
+
+	i.cancel()
+
+	if i.revocationURL == "" || token == nil {
+		return nil
 	}
 
-	return o.keys.Sign(in)
+	return revokeOAuthToken(i.revocationURL, i.config.ClientID, i.config.ClientSecret, token)
 }
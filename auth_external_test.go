@@ -0,0 +1,232 @@
+package connect_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+	"golang.org/x/oauth2"
+)
+
+// newClientCredentialsClient Creates a token client that uses the client
+// credentials flow against the fake API
+func newClientCredentialsClient(api *connecttest.APIServer, config connect.ClientCredentialsConfig) *connect.OAuthTokenClient {
+	config.ClientID = connecttest.ClientID
+	config.ClientSecret = connecttest.ClientSecret
+
+	return connect.NewOAuthTokenClient(api.OAuthURL, api.ExchangeURL, config)
+}
+
+// lastOAuthForm Returns the form of the most recent OAuth request
+func lastOAuthForm(t *testing.T, api *connecttest.APIServer) url.Values {
+	t.Helper()
+
+	requests := api.Requests(connecttest.EndpointOAuth)
+
+	if len(requests) == 0 {
+		t.Fatal("expected an OAuth request")
+	}
+
+	return requests[len(requests)-1].Form
+}
+
+// encodeIssuedAt Encodes the claims as though they were issued at `issued`.
+// `Encode()` always uses the current time, so the payload is rewritten and
+// signed again
+func encodeIssuedAt(t *testing.T, claims *jwt.UserClaims, keys nkeys.KeyPair, issued time.Time) string {
+	t.Helper()
+
+	token, err := claims.Encode(keys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+
+	err = decoder.Decode(&fields)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields["iat"] = issued.Unix()
+
+	payload, err = json.Marshal(fields)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := keys.Sign([]byte(signed))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOAuthTokenClientParams(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	t.Run("with defaults", func(t *testing.T) {
+		c := newClientCredentialsClient(api, connect.ClientCredentialsConfig{})
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := lastOAuthForm(t, api)
+
+		if form.Get("audience") != connect.DefaultAudience {
+			t.Errorf("expected audience %v, got %v", connect.DefaultAudience, form.Get("audience"))
+		}
+
+		if _, ok := form["scope"]; ok {
+			t.Errorf("expected no scope, got %v", form.Get("scope"))
+		}
+	})
+
+	t.Run("with an identity", func(t *testing.T) {
+		c := newClientCredentialsClient(api, connect.ClientCredentialsConfig{})
+		c.Identity = connect.Identity{
+			NameTemplate: "{{.ServiceName}}/{{.PodName}}",
+			ServiceName:  "test-service",
+			PodName:      "test-pod",
+		}
+
+		token, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := jwt.DecodeUserClaims(token)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if claims.Name != "test-service/test-pod" {
+			t.Errorf("expected user name from identity, got %v", claims.Name)
+		}
+	})
+
+	t.Run("with audience, scopes and extra params", func(t *testing.T) {
+		c := newClientCredentialsClient(api, connect.ClientCredentialsConfig{
+			Audience: "https://api.staging.overmind.tech",
+			Scopes:   []string{"read:sources", "request:receive"},
+			EndpointParams: url.Values{
+				"organization": []string{"org_test"},
+				"audience":     []string{"ignored"},
+			},
+		})
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := lastOAuthForm(t, api)
+
+		if form.Get("audience") != "https://api.staging.overmind.tech" {
+			t.Errorf("expected staging audience, got %v", form["audience"])
+		}
+
+		if form.Get("scope") != "read:sources request:receive" {
+			t.Errorf("expected scopes to be sent, got %v", form.Get("scope"))
+		}
+
+		if form.Get("organization") != "org_test" {
+			t.Errorf("expected extra param to be sent, got %v", form.Get("organization"))
+		}
+	})
+}
+
+func TestOAuthTokenClientInvalidateJWT(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	c := api.NewTokenClient("")
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := c.Signer
+
+	c.InvalidateJWT()
+
+	if c.CachedJWT() != "" {
+		t.Error("expected cached JWT to be cleared")
+	}
+
+	token, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token == "" {
+		t.Error("expected a new JWT")
+	}
+
+	if c.Signer != signer {
+		t.Error("expected NKeys to be kept")
+	}
+}
+
+func TestOAuthTokenClientCachedAccessToken(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	api.AcceptAccessToken("cached")
+
+	// Like most token sources, this returns the same token until it expires
+	c := connect.NewOAuthTokenClientWithTokenSource(api.ExchangeURL, "", oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: "cached",
+		Expiry:      time.Now().Add(time.Hour),
+	}))
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := c.AccessTokenFetched()
+
+	c.InvalidateJWT()
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Otherwise the token's lifetime would appear to shrink every time
+	if !c.AccessTokenFetched().Equal(fetched) {
+		t.Errorf("expected the access token to be treated as fetched at %v, got %v", fetched, c.AccessTokenFetched())
+	}
+}
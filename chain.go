@@ -0,0 +1,204 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ChainMaxFailuresDefault How many times in a row the token client that is in
+// use can fail before `ChainTokenClient` falls back to trying every client
+// again
+const ChainMaxFailuresDefault = 3
+
+// ChainLink A token client in a `ChainTokenClient`, along with a name that is
+// used in logs to show which source of credentials is being used. The name
+// must not contain any secrets
+type ChainLink struct {
+	Name   string
+	Client TokenClient
+}
+
+// ChainTokenClient Tries a list of token clients in order and uses the first
+// one that succeeds. This allows the same binary to work in environments that
+// provide credentials in different ways e.g. a mounted creds file, a workload
+// identity, or client credentials from the environment. Once a client has
+// succeeded it continues to be used until it fails `MaxFailures` times in a
+// row, at which point the list is tried again from the start
+type ChainTokenClient struct {
+	// How many consecutive failures of the client in use are tolerated before
+	// falling back. Defaults to `ChainMaxFailuresDefault`
+	MaxFailures int
+
+	links []ChainLink
+
+	mutex    sync.Mutex
+	current  *ChainLink
+	failures int
+}
+
+// NewChainTokenClient Creates a token client that tries each of the supplied
+// token clients in order
+func NewChainTokenClient(links ...ChainLink) *ChainTokenClient {
+	return &ChainTokenClient{
+		links: links,
+	}
+}
+
+// Current Returns the name of the token client that is currently in use, or
+// an empty string if none has succeeded yet
+func (c *ChainTokenClient) Current() string {
+	current := c.currentLink()
+
+	if current == nil {
+		return ""
+	}
+
+	return current.Name
+}
+
+func (c *ChainTokenClient) GetJWT() (string, error) {
+	return c.GetJWTContext(context.Background())
+}
+
+// GetJWTContext Gets a JWT from the token client in use, passing the context
+// to clients that support it. The lock isn't held while the client is getting
+// the JWT, since that can involve retries or an interactive login
+func (c *ChainTokenClient) GetJWTContext(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	current := c.current
+	c.mutex.Unlock()
+
+	if current != nil {
+		token, err := AsContextTokenClient(current.Client).GetJWTContext(ctx)
+
+		if !c.recordResult(current, err) {
+			return token, err
+		}
+	}
+
+	return c.selectLink(ctx)
+}
+
+// recordResult Records the result of getting a JWT from `link`, returning true
+// if it has now failed too many times and the chain should be tried again.
+// Results from a link that is no longer in use are ignored
+func (c *ChainTokenClient) recordResult(link *ChainLink, err error) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.current != link {
+		return false
+	}
+
+	if err == nil {
+		c.failures = 0
+		return false
+	}
+
+	c.failures++
+
+	maxFailures := c.MaxFailures
+
+	if maxFailures == 0 {
+		maxFailures = ChainMaxFailuresDefault
+	}
+
+	log.WithFields(log.Fields{
+		"source":   link.Name,
+		"error":    err,
+		"failures": c.failures,
+	}).Error("NATS token source failed")
+
+	if c.failures < maxFailures {
+		return false
+	}
+
+	c.current = nil
+	c.failures = 0
+
+	return true
+}
+
+// selectLink Tries each token client in order, storing and returning the
+// result of the first one that succeeds
+func (c *ChainTokenClient) selectLink(ctx context.Context) (string, error) {
+	if len(c.links) == 0 {
+		return "", errors.New("no token clients configured")
+	}
+
+	errs := make([]string, 0, len(c.links))
+
+	for i := range c.links {
+		link := &c.links[i]
+
+		token, err := AsContextTokenClient(link.Client).GetJWTContext(ctx)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"source": link.Name,
+				"error":  err,
+			}).Debug("NATS token source unavailable")
+
+			errs = append(errs, fmt.Sprintf("%v: %v", link.Name, err))
+
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"source": link.Name,
+		}).Info("Using NATS token source")
+
+		c.mutex.Lock()
+		c.current = link
+		c.failures = 0
+		c.mutex.Unlock()
+
+		return token, nil
+	}
+
+	return "", fmt.Errorf("all token clients failed: %v", strings.Join(errs, "; "))
+}
+
+// currentLink Returns the link that is in use, or nil if none has succeeded
+// yet
+func (c *ChainTokenClient) currentLink() *ChainLink {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.current
+}
+
+// InvalidateJWT Invalidates the JWT of the token client that is currently in
+// use, as long as it implements `Invalidator`
+func (c *ChainTokenClient) InvalidateJWT() {
+	current := c.currentLink()
+
+	if current == nil {
+		return
+	}
+
+	if i, ok := current.Client.(Invalidator); ok {
+		i.InvalidateJWT()
+	}
+}
+
+func (c *ChainTokenClient) Sign(in []byte) ([]byte, error) {
+	return c.SignContext(context.Background(), in)
+}
+
+// SignContext Signs using the token client in use, passing the context to
+// clients that support it
+func (c *ChainTokenClient) SignContext(ctx context.Context, in []byte) ([]byte, error) {
+	current := c.currentLink()
+
+	if current == nil {
+		return []byte{}, errors.New("no token client has succeeded yet, call GetJWT first")
+	}
+
+	return AsContextTokenClient(current.Client).SignContext(ctx, in)
+}
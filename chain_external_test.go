@@ -0,0 +1,213 @@
+package connect_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+// newChainMock Creates a mock token client for a chain link
+func newChainMock(t *testing.T) *connecttest.MockTokenClient {
+	t.Helper()
+
+	m, err := connecttest.NewMockTokenClient(connecttest.UserConfig{Name: t.Name()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestChainTokenClient(t *testing.T) {
+	t.Run("uses the first working client", func(t *testing.T) {
+		creds := connect.NewCredsFileTokenClient(filepath.Join(t.TempDir(), "missing.creds"))
+		second := newChainMock(t)
+		third := newChainMock(t)
+
+		c := connect.NewChainTokenClient(
+			connect.ChainLink{Name: "creds file", Client: creds},
+			connect.ChainLink{Name: "second", Client: second},
+			connect.ChainLink{Name: "third", Client: third},
+		)
+
+		token, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if jwts := second.JWTs(); len(jwts) != 1 || token != jwts[0] {
+			t.Errorf("expected token from second client, got %v", token)
+		}
+
+		if c.Current() != "second" {
+			t.Errorf("expected current client to be second, got %v", c.Current())
+		}
+
+		third.AssertGetJWTCalls(t, 0)
+
+		data := []byte{1, 156, 230, 4, 23, 175, 11}
+
+		signed, err := c.Sign(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubKeys, err := nkeys.FromPublicKey(second.PublicKey())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = pubKeys.Verify(data, signed)
+
+		if err != nil {
+			t.Error("expected data to be signed by the client in use")
+		}
+
+		// The mocks don't support introspection
+		_, err = c.Introspect()
+
+		if err == nil {
+			t.Error("expected introspection to fail")
+		}
+	})
+
+	t.Run("remembers the client that succeeded", func(t *testing.T) {
+		first := newChainMock(t)
+		first.FailGetJWT()
+		second := newChainMock(t)
+
+		c := connect.NewChainTokenClient(
+			connect.ChainLink{Name: "first", Client: first},
+			connect.ChainLink{Name: "second", Client: second},
+		)
+
+		for i := 0; i < 3; i++ {
+			_, err := c.GetJWT()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		first.AssertGetJWTCalls(t, 1)
+	})
+
+	t.Run("falls back after repeated failures", func(t *testing.T) {
+		first := newChainMock(t)
+		first.FailGetJWT()
+		second := newChainMock(t)
+
+		c := connect.NewChainTokenClient(
+			connect.ChainLink{Name: "first", Client: first},
+			connect.ChainLink{Name: "second", Client: second},
+		)
+		c.MaxFailures = 2
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The first client becomes available and the second breaks
+		second.FailGetJWT(connecttest.ErrMockFailure, connecttest.ErrMockFailure)
+
+		// A single failure is returned as-is
+		_, err = c.GetJWT()
+
+		if err == nil {
+			t.Error("expected an error from the current client")
+		}
+
+		// After the second failure the chain is tried again
+		token, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if jwts := first.JWTs(); len(jwts) != 1 || token != jwts[0] {
+			t.Errorf("expected to fall back to first client, got %v", token)
+		}
+
+		if c.Current() != "first" {
+			t.Errorf("expected current client to be first, got %v", c.Current())
+		}
+	})
+
+	t.Run("with every client failing", func(t *testing.T) {
+		first := newChainMock(t)
+		first.FailGetJWT()
+		second := newChainMock(t)
+		second.FailGetJWT()
+
+		c := connect.NewChainTokenClient(
+			connect.ChainLink{Name: "first", Client: first},
+			connect.ChainLink{Name: "second", Client: second},
+		)
+
+		_, err := c.GetJWT()
+
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		_, err = c.Sign([]byte{1})
+
+		if err == nil {
+			t.Error("expected signing to fail before any client has succeeded")
+		}
+	})
+}
+
+func TestChainTokenClientSlowLink(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	c := connect.NewChainTokenClient(connect.ChainLink{Name: "oauth", Client: api.NewTokenClient("")})
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.InvalidateJWT()
+	api.Script(connecttest.EndpointCreateToken, connecttest.Failure{Latency: 500 * time.Millisecond})
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := c.GetJWT()
+		errs <- err
+	}()
+
+	// Give the slow request time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// Neither of these wait for the link to get its JWT
+	start := time.Now()
+
+	c.InvalidateJWT()
+
+	_, err = c.Sign([]byte("nonce"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected invalidating and signing not to wait for the JWT, took %v", elapsed)
+	}
+
+	if err = <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,26 @@
+package connect
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestChainTokenClientIntrospect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.creds")
+	keys := writeTestCredsFile(t, path)
+	pubKey, _ := keys.PublicKey()
+
+	c := NewChainTokenClient(
+		ChainLink{Name: "creds file", Client: NewCredsFileTokenClient(path)},
+	)
+
+	info, err := c.Introspect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Subject != pubKey {
+		t.Errorf("expected subject %v, got %v", pubKey, info.Subject)
+	}
+}
@@ -0,0 +1,192 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// RevocationTimeoutDefault How long to wait for a revocation endpoint to
+// respond when a token client is closed
+const RevocationTimeoutDefault = 10 * time.Second
+
+// ErrTokenClientClosed Returned when a token client is used after it has been
+// closed
+var ErrTokenClientClosed = errors.New("token client is closed")
+
+// wiper Is implemented by things that hold key material which can be wiped
+// from memory, such as `nkeys.KeyPair`
+type wiper interface {
+	Wipe()
+}
+
+// wipe Wipes the signer's key material from memory if it supports it
+func wipe(signer Signer) {
+	if w, ok := signer.(wiper); ok {
+		w.Wipe()
+	}
+}
+
+// Close Forgets the JWT and signer. The signer was supplied by the caller, so
+// it isn't wiped. The client can't be used afterwards
+func (b *BasicTokenClient) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	b.staticToken = ""
+	b.staticSigner = nil
+
+	return nil
+}
+
+// Close Wipes the JWT, and the NKey if the client generated it, then revokes
+// the OAuth token if the flow has a revocation endpoint. A `Signer` that was
+// set by the caller is left alone. The client can't be used afterwards
+func (o *OAuthTokenClient) Close() error {
+	o.mutex.Lock()
+
+	if o.closed {
+		o.mutex.Unlock()
+		return nil
+	}
+
+	o.closed = true
+	o.jwt = ""
+	o.accessToken = ""
+
+	// If a JWT is being fetched the NKey is still in use, so it is wiped once
+	// that has finished instead
+	if o.generatedSigner && o.generating == nil {
+		wipe(o.Signer)
+	}
+
+	o.Signer = nil
+	o.mutex.Unlock()
+
+	// Revoking makes a request, so it's done without holding the lock
+	if closer, ok := o.tokenSource.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Close Wipes the JWT and NKey that were read from the creds file. The file
+// itself is left alone. The client can't be used afterwards
+func (c *CredsFileTokenClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	c.jwt = ""
+
+	if c.keys != nil {
+		c.keys.Wipe()
+		c.keys = nil
+	}
+
+	return nil
+}
+
+// Close Closes every token client in the chain that implements `io.Closer`
+func (c *ChainTokenClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.current = nil
+
+	errs := make([]string, 0)
+
+	for _, link := range c.links {
+		closer, ok := link.Client.(io.Closer)
+
+		if !ok {
+			continue
+		}
+
+		err := closer.Close()
+
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", link.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("closing token clients failed: %v", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// revokeToken Revokes an OAuth token using the token revocation endpoint at
+// `revocationURL` (RFC 7009). `tokenTypeHint` is either `refresh_token` or
+// `access_token`. If `clientSecret` is empty the client is treated as a
+// public client
+func revokeToken(ctx context.Context, revocationURL string, clientID string, clientSecret string, token string, tokenTypeHint string) error {
+	form := url.Values{
+		"token":           []string{token},
+		"token_type_hint": []string{tokenTypeHint},
+	}
+
+	if clientSecret == "" {
+		form.Set("client_id", clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revocationURL, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	response, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("revoking token failed: %w", err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<16))
+		_, message := parseErrorBody(body)
+
+		return fmt.Errorf("revoking token failed: %v: %v", response.Status, message)
+	}
+
+	return nil
+}
+
+// revokeOAuthToken Revokes the refresh token if there is one, otherwise the
+// access token
+func revokeOAuthToken(revocationURL string, clientID string, clientSecret string, token *oauth2.Token) error {
+	ctx, cancel := context.WithTimeout(context.Background(), RevocationTimeoutDefault)
+	defer cancel()
+
+	if token.RefreshToken != "" {
+		return revokeToken(ctx, revocationURL, clientID, clientSecret, token.RefreshToken, "refresh_token")
+	}
+
+	return revokeToken(ctx, revocationURL, clientID, clientSecret, token.AccessToken, "access_token")
+}
@@ -0,0 +1,265 @@
+package connect_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestOAuthTokenClientClose(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	c := api.NewTokenClient("")
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := c.Signer.(nkeys.KeyPair)
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seed, _ := keys.Seed(); len(seed) != 0 {
+		t.Error("expected keys to be wiped")
+	}
+
+	if c.CachedJWT() != "" || c.Signer != nil {
+		t.Error("expected JWT and signer to be cleared")
+	}
+
+	if _, err = c.GetJWT(); !errors.Is(err, connect.ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+
+	if _, err = c.Sign([]byte{1}); !errors.Is(err, connect.ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+}
+
+func TestOAuthTokenClientCloseWhileFetching(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	api.Script(connecttest.EndpointCreateToken, connecttest.Failure{Latency: 500 * time.Millisecond})
+
+	c := api.NewTokenClient("")
+
+	// Generate the keys before fetching the JWT
+	_, err := c.Sign([]byte{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := c.Signer.(nkeys.KeyPair)
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := c.GetJWT()
+		errs <- err
+	}()
+
+	// Give the slow request time to start
+	time.Sleep(100 * time.Millisecond)
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are still being used, so they are wiped once the JWT has been
+	// fetched
+	if err = <-errs; !errors.Is(err, connect.ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+
+	if seed, _ := keys.Seed(); len(seed) != 0 {
+		t.Error("expected keys to be wiped")
+	}
+}
+
+func TestAuthCodeTokenClientCloseDuringLogin(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	opened := make(chan struct{})
+
+	c := connect.NewAuthCodeTokenClient(api.ExchangeURL, connect.AuthCodeConfig{
+		ClientID: connecttest.ClientID,
+		AuthURL:  api.AuthURL,
+		TokenURL: api.OAuthURL,
+		OpenBrowser: func(authURL string) error {
+			// The user never logs in
+			close(opened)
+			return nil
+		},
+		Output:  io.Discard,
+		Timeout: time.Minute,
+	})
+
+	errs := make(chan error, 1)
+
+	go func() {
+		_, err := c.GetJWT()
+		errs <- err
+	}()
+
+	<-opened
+
+	closed := make(chan error, 1)
+
+	go func() {
+		closed <- c.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close not to wait for the login")
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, connect.ErrTokenClientClosed) {
+			t.Errorf("expected ErrTokenClientClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the login to be cancelled")
+	}
+}
+
+func TestOAuthTokenClientCloseWithSigner(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := api.NewTokenClient("")
+	c.Signer = keys
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = keys.Seed(); err != nil {
+		t.Errorf("expected the caller's keys to be left alone, got %v", err)
+	}
+
+	if c.Signer != nil {
+		t.Error("expected the signer to be forgotten")
+	}
+}
+
+func TestRefreshTokenClientClose(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	refreshToken, err := api.IssueRefreshToken()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated string
+
+	c := connect.NewRefreshTokenClient(api.ExchangeURL, connect.RefreshTokenConfig{
+		ClientID:      connecttest.ClientID,
+		TokenURL:      api.OAuthURL,
+		RefreshToken:  refreshToken,
+		RevocationURL: api.RevocationURL,
+		OnRotate: func(refreshToken string) {
+			rotated = refreshToken
+		},
+	})
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revocations := api.Requests(connecttest.EndpointRevoke)
+
+	if len(revocations) != 1 {
+		t.Fatalf("expected the refresh token to be revoked, got %v requests", len(revocations))
+	}
+
+	form := revocations[0].Form
+
+	if rotated == "" || form.Get("token") != rotated {
+		t.Errorf("expected the current refresh token to be revoked, got %v", form.Get("token"))
+	}
+
+	if form.Get("token_type_hint") != "refresh_token" || form.Get("client_id") != connecttest.ClientID {
+		t.Errorf("unexpected revocation request %v", form)
+	}
+
+	if c.RefreshToken() != "" {
+		t.Error("expected the refresh token to be forgotten")
+	}
+}
+
+func TestChainTokenClientClose(t *testing.T) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	basic := connect.NewBasicTokenClient("token", keys)
+
+	failing := newChainMock(t)
+	failing.FailGetJWT()
+
+	c := connect.NewChainTokenClient(
+		// Hides the mock's Close method
+		connect.ChainLink{Name: "not closable", Client: struct{ connect.TokenClient }{failing}},
+		connect.ChainLink{Name: "basic", Client: basic},
+	)
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = basic.GetJWT(); !errors.Is(err, connect.ErrTokenClientClosed) {
+		t.Errorf("expected basic client to be closed, got %v", err)
+	}
+
+	if c.Current() != "" {
+		t.Error("expected no client to be in use")
+	}
+}
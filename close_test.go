@@ -0,0 +1,139 @@
+package connect
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+func TestBasicTokenClientClose(t *testing.T) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c io.Closer = NewBasicTokenClient("token", keys)
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := c.(*BasicTokenClient)
+
+	if _, err = b.GetJWT(); !errors.Is(err, ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+
+	if _, err = b.Sign([]byte{1}); !errors.Is(err, ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+
+	if _, err = keys.Seed(); err != nil {
+		t.Errorf("expected the caller's keys to be left alone, got %v", err)
+	}
+
+	// Closing twice is fine
+	err = c.Close()
+
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCredsFileTokenClientClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.creds")
+	writeTestCredsFile(t, path)
+
+	c := NewCredsFileTokenClient(path)
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.keys != nil || c.jwt != "" {
+		t.Error("expected keys and JWT to be cleared")
+	}
+
+	if _, err = c.GetJWT(); !errors.Is(err, ErrTokenClientClosed) {
+		t.Errorf("expected ErrTokenClientClosed, got %v", err)
+	}
+}
+
+func TestToNatsOptionsClose(t *testing.T) {
+	newClient := func() *BasicTokenClient {
+		keys, err := nkeys.CreateUser()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return NewBasicTokenClient("token", keys)
+	}
+
+	t.Run("with CloseTokenClient", func(t *testing.T) {
+		client := newClient()
+
+		var closedHandlerUsed bool
+
+		o := NATSOptions{
+			TokenClient:      client,
+			ClosedHandler:    func(c *nats.Conn) { closedHandlerUsed = true },
+			CloseTokenClient: true,
+		}
+
+		_, options := o.ToNatsOptions()
+
+		actualOptions, err := optionsToStruct(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actualOptions.ClosedCB(nil)
+
+		if !closedHandlerUsed {
+			t.Error("expected ClosedHandler to be used")
+		}
+
+		if _, err = client.GetJWT(); !errors.Is(err, ErrTokenClientClosed) {
+			t.Errorf("expected token client to be closed, got %v", err)
+		}
+	})
+
+	t.Run("keeps the token client open by default", func(t *testing.T) {
+		client := newClient()
+
+		o := NATSOptions{
+			TokenClient: client,
+		}
+
+		_, options := o.ToNatsOptions()
+
+		actualOptions, err := optionsToStruct(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actualOptions.ClosedCB(nil)
+
+		if _, err = client.GetJWT(); err != nil {
+			t.Errorf("expected token client to be open, got %v", err)
+		}
+	})
+}
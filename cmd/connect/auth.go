@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+)
+
+// authURLDefault Where Overmind's OAuth endpoints are
+const authURLDefault = "https://auth.overmind.tech"
+
+// authFlags The flags that choose how to get a NATS token. These are shared
+// by every command that needs one
+type authFlags struct {
+	credsFile    string
+	device       bool
+	authURL      string
+	apiURL       string
+	clientID     string
+	clientSecret string
+	account      string
+	audience     string
+	scopes       string
+}
+
+// register Adds the flags to `flags`. Defaults are taken from the environment
+// so that secrets don't need to be passed on the command line
+func (a *authFlags) register(flags *flag.FlagSet) {
+	authURL := os.Getenv("OVERMIND_AUTH_URL")
+
+	if authURL == "" {
+		authURL = authURLDefault
+	}
+
+	flags.StringVar(&a.credsFile, "creds", os.Getenv("NATS_CREDS"), "Use the JWT and NKey from this `.creds file` (env NATS_CREDS)")
+	flags.BoolVar(&a.device, "device", false, "Log in interactively using the device authorization flow")
+	flags.StringVar(&a.authURL, "auth-url", authURL, "The base `URL` of the OAuth server (env OVERMIND_AUTH_URL)")
+	flags.StringVar(&a.apiURL, "api-url", os.Getenv("OVERMIND_API_URL"), "The `URL` of the Overmind API, used to exchange OAuth tokens for NATS tokens (env OVERMIND_API_URL)")
+	flags.StringVar(&a.clientID, "client-id", os.Getenv("OVERMIND_CLIENT_ID"), "The OAuth client `ID` (env OVERMIND_CLIENT_ID)")
+	flags.StringVar(&a.clientSecret, "client-secret", os.Getenv("OVERMIND_CLIENT_SECRET"), "The OAuth client `secret`, for the client credentials flow (env OVERMIND_CLIENT_SECRET)")
+	flags.StringVar(&a.account, "account", os.Getenv("OVERMIND_ACCOUNT"), "Request a token for this `account`. Requires admin permissions (env OVERMIND_ACCOUNT)")
+	flags.StringVar(&a.audience, "audience", connect.DefaultAudience, "The OAuth `audience`")
+	flags.StringVar(&a.scopes, "scopes", "", "Comma separated OAuth `scopes` to request")
+}
+
+// tokenClient Creates the token client that the flags describe, along with
+// the NKey that it signs with if it is held locally. The client should be
+// closed when it is no longer needed
+func (a *authFlags) tokenClient(stderr io.Writer) (connect.TokenClient, nkeys.KeyPair, error) {
+	if a.credsFile != "" {
+		contents, err := os.ReadFile(a.credsFile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys, err := jwt.ParseDecoratedNKey(contents)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading NKey from %v failed: %w", a.credsFile, err)
+		}
+
+		return connect.NewCredsFileTokenClient(a.credsFile), keys, nil
+	}
+
+	if a.apiURL == "" {
+		return nil, nil, errors.New("either -creds or -api-url is required")
+	}
+
+	if a.clientID == "" {
+		return nil, nil, errors.New("-client-id is required")
+	}
+
+	// Generate the NKey here rather than letting the client do it, so that it
+	// can be written to a .creds file
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var scopes []string
+
+	if a.scopes != "" {
+		scopes = strings.Split(a.scopes, ",")
+	}
+
+	authURL := strings.TrimSuffix(a.authURL, "/")
+
+	if a.device {
+		client := connect.NewDeviceFlowTokenClient(a.apiURL, connect.DeviceFlowConfig{
+			ClientID:      a.clientID,
+			DeviceAuthURL: authURL + "/oauth/device/code",
+			TokenURL:      authURL + "/oauth/token",
+			Scopes:        scopes,
+			Audience:      a.audience,
+			Account:       a.account,
+			Output:        stderr,
+		})
+		client.Signer = keys
+
+		return client, keys, nil
+	}
+
+	if a.clientSecret == "" {
+		return nil, nil, errors.New("-client-secret is required, or use -device to log in interactively")
+	}
+
+	client := connect.NewOAuthTokenClient(authURL+"/oauth/token", a.apiURL, connect.ClientCredentialsConfig{
+		ClientID:     a.clientID,
+		ClientSecret: a.clientSecret,
+		Account:      a.account,
+		Audience:     a.audience,
+		Scopes:       scopes,
+	})
+	client.Signer = keys
+
+	return client, keys, nil
+}
@@ -0,0 +1,844 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// rttWarningThreshold Round trip times above this are reported as a warning
+const rttWarningThreshold = 500 * time.Millisecond
+
+// certExpiryWarning Certificates that expire sooner than this are reported as
+// a warning
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkStatus The outcome of a single check
+type checkStatus string
+
+const (
+	statusPass checkStatus = "pass"
+	statusWarn checkStatus = "warn"
+	statusFail checkStatus = "fail"
+	statusSkip checkStatus = "skip"
+)
+
+// checkResult The result of one stage of the doctor, for one server if the
+// stage is run per server
+type checkResult struct {
+	Stage  string      `json:"stage"`
+	Target string      `json:"target,omitempty"`
+	Status checkStatus `json:"status"`
+	Detail string      `json:"detail"`
+	Hint   string      `json:"hint,omitempty"`
+}
+
+// oauthTokener Is implemented by token clients that use OAuth, such as
+// `OAuthTokenClient` and `DeviceFlowTokenClient`
+type oauthTokener interface {
+	OAuthToken() (*oauth2.Token, error)
+}
+
+// natsInfo The fields of the NATS INFO message that the doctor uses
+type natsInfo struct {
+	ServerID     string `json:"server_id"`
+	Version      string `json:"version"`
+	TLSRequired  bool   `json:"tls_required"`
+	TLSAvailable bool   `json:"tls_available"`
+}
+
+// doctor Checks each stage of connecting using a set of `NATSOptions`, from
+// DNS through to permissions
+type doctor struct {
+	options   connect.NATSOptions
+	timeout   time.Duration
+	publish   []string
+	subscribe []string
+	// The CAs to verify server certificates with. Uses the system's if nil
+	roots *x509.CertPool
+
+	results []checkResult
+}
+
+func doctorCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: connect doctor [flags]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Checks each stage of connecting to NATS and suggests how to fix any problems")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	var auth authFlags
+	auth.register(flags)
+
+	var natsConfig natsFlags
+	natsConfig.register(flags)
+
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	publish := flags.String("publish", "", "Comma separated `subjects` that the token must allow publishing to")
+	subscribe := flags.String("subscribe", "", "Comma separated `subjects` that the token must allow subscribing to")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 2
+	}
+
+	client, _, err := auth.tokenClient(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	defer closeTokenClient(client, stderr)
+
+	options, err := natsConfig.options(client)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// Problems are reported by the checks, so the library's logs would only
+	// repeat them
+	log.SetLevel(log.PanicLevel)
+
+	d := doctor{
+		options:   options,
+		timeout:   natsConfig.timeout,
+		publish:   splitList(*publish),
+		subscribe: splitList(*subscribe),
+	}
+
+	results := d.run(context.Background())
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(results)
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	} else {
+		printResults(stdout, results)
+	}
+
+	for _, r := range results {
+		if r.Status == statusFail {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// run Runs every check and returns the results. Checks that depend on an
+// earlier stage that failed are skipped
+func (d *doctor) run(ctx context.Context) []checkResult {
+	d.results = nil
+
+	var reachable bool
+
+	for _, server := range d.options.Servers {
+		if d.checkServer(ctx, server) {
+			reachable = true
+		}
+	}
+
+	tokenOK := d.checkOAuth()
+
+	if tokenOK {
+		tokenOK = d.checkJWT()
+	} else {
+		d.skip("NATS JWT", "", "no OAuth access token is available")
+	}
+
+	d.checkConnect(reachable, tokenOK)
+	d.checkPermissions(tokenOK)
+
+	return d.results
+}
+
+func (d *doctor) add(result checkResult) {
+	d.results = append(d.results, result)
+}
+
+// skip Records that a stage was skipped
+func (d *doctor) skip(stage string, target string, reason string) {
+	d.add(checkResult{
+		Stage:  stage,
+		Target: target,
+		Status: statusSkip,
+		Detail: reason,
+	})
+}
+
+// checkServer Checks DNS, TCP and TLS for a single server, returning whether
+// it could be reached
+func (d *doctor) checkServer(ctx context.Context, server string) bool {
+	u, err := parseServerURL(server)
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "DNS",
+			Target: server,
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   "Server URLs should look like nats://nats.example.com:4222, tls://, ws:// or wss://",
+		})
+
+		d.skip("TCP", server, "the URL is invalid")
+		d.skip("TLS", server, "the URL is invalid")
+
+		return false
+	}
+
+	host := u.Hostname()
+	address := net.JoinHostPort(host, u.Port())
+
+	if !d.checkDNS(ctx, host) {
+		d.skip("TCP", address, "DNS resolution failed")
+		d.skip("TLS", address, "DNS resolution failed")
+
+		return false
+	}
+
+	conn, ok := d.checkTCP(ctx, address)
+
+	if !ok {
+		d.skip("TLS", address, "the server isn't reachable")
+		return false
+	}
+
+	defer conn.Close()
+
+	switch u.Scheme {
+	case "ws":
+		d.skip("TLS", address, "ws:// doesn't use TLS")
+		return true
+	case "wss":
+		// The handshake comes before the WebSocket upgrade, so there's no
+		// INFO message to read first
+		return d.checkCertificate(ctx, conn, address, host)
+	default:
+		return d.checkTLS(ctx, conn, address, host, u.Scheme == "tls")
+	}
+}
+
+func (d *doctor) checkDNS(ctx context.Context, host string) bool {
+	if net.ParseIP(host) != nil {
+		d.add(checkResult{
+			Stage:  "DNS",
+			Target: host,
+			Status: statusPass,
+			Detail: "is an IP address",
+		})
+
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "DNS",
+			Target: host,
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   "Check that the hostname is spelled correctly, and that the DNS servers used by this machine can resolve it",
+		})
+
+		return false
+	}
+
+	d.add(checkResult{
+		Stage:  "DNS",
+		Target: host,
+		Status: statusPass,
+		Detail: fmt.Sprintf("resolved to %v", strings.Join(addresses, ", ")),
+	})
+
+	return true
+}
+
+func (d *doctor) checkTCP(ctx context.Context, address string) (net.Conn, bool) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	start := time.Now()
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		_, port, _ := net.SplitHostPort(address)
+
+		d.add(checkResult{
+			Stage:  "TCP",
+			Target: address,
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   fmt.Sprintf("Check that outbound connections to port %v are allowed by any firewalls, security groups or proxies", port),
+		})
+
+		return nil, false
+	}
+
+	d.add(checkResult{
+		Stage:  "TCP",
+		Target: address,
+		Status: statusPass,
+		Detail: fmt.Sprintf("connected to %v in %v", conn.RemoteAddr(), time.Since(start).Round(time.Millisecond)),
+	})
+
+	return conn, true
+}
+
+// checkTLS Reads the server's INFO message to see whether it requires TLS,
+// and if it does, performs the handshake and checks the certificate
+func (d *doctor) checkTLS(ctx context.Context, conn net.Conn, address string, host string, wantTLS bool) bool {
+	conn.SetDeadline(time.Now().Add(d.timeout))
+
+	info, err := readINFO(conn)
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusFail,
+			Detail: fmt.Sprintf("reading the server's INFO message failed: %v", err),
+			Hint:   "Check that this is a NATS client port, and not for example an HTTP port or a proxy that doesn't support NATS",
+		})
+
+		return false
+	}
+
+	if !info.TLSRequired && !wantTLS {
+		d.skip("TLS", address, "the server doesn't require TLS")
+		return true
+	}
+
+	if !info.TLSRequired && !info.TLSAvailable {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusFail,
+			Detail: "the URL uses tls:// but the server doesn't support TLS",
+			Hint:   "Use a nats:// URL, or enable TLS on the server",
+		})
+
+		return false
+	}
+
+	return d.checkCertificate(ctx, conn, address, host)
+}
+
+// checkCertificate Performs the TLS handshake and checks the server's
+// certificate
+func (d *doctor) checkCertificate(ctx context.Context, conn net.Conn, address string, host string) bool {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	// Verification is done separately so that the certificate can be
+	// described even if it isn't trusted
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+
+	err := tlsConn.HandshakeContext(ctx)
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusFail,
+			Detail: fmt.Sprintf("handshake failed: %v", err),
+			Hint:   "Check that nothing between here and the server is intercepting TLS, and that the server's TLS configuration is valid",
+		})
+
+		return false
+	}
+
+	state := tlsConn.ConnectionState()
+	certs := state.PeerCertificates
+
+	if len(certs) == 0 {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusFail,
+			Detail: "the server didn't send a certificate",
+			Hint:   "Check that the server has a certificate configured, and that nothing between here and the server is terminating TLS",
+		})
+
+		return false
+	}
+
+	cert := certs[0]
+	detail := fmt.Sprintf("%v, certificate for %v issued by %v, valid until %v",
+		tlsVersionName(state.Version),
+		certificateNames(cert),
+		cert.Issuer.CommonName,
+		cert.NotAfter.Format(time.RFC3339),
+	)
+
+	intermediates := x509.NewCertPool()
+
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         d.roots,
+		Intermediates: intermediates,
+	})
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusFail,
+			Detail: fmt.Sprintf("%v: %v", detail, err),
+			Hint:   "The certificate isn't trusted or doesn't match the hostname. If the server uses a private CA, add it to this machine's trusted certificates",
+		})
+
+		return false
+	}
+
+	if time.Until(cert.NotAfter) < certExpiryWarning {
+		d.add(checkResult{
+			Stage:  "TLS",
+			Target: address,
+			Status: statusWarn,
+			Detail: detail,
+			Hint:   "The certificate expires soon and should be renewed",
+		})
+
+		return true
+	}
+
+	d.add(checkResult{
+		Stage:  "TLS",
+		Target: address,
+		Status: statusPass,
+		Detail: detail,
+	})
+
+	return true
+}
+
+func (d *doctor) checkOAuth() bool {
+	tokener, ok := d.options.TokenClient.(oauthTokener)
+
+	if !ok {
+		d.skip("OAuth", "", "the token client doesn't use OAuth")
+		return true
+	}
+
+	token, err := tokener.OAuthToken()
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "OAuth",
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   tokenErrorHint(err),
+		})
+
+		return false
+	}
+
+	detail := "got an access token"
+
+	if !token.Expiry.IsZero() {
+		detail = fmt.Sprintf("got an access token valid until %v", token.Expiry.Format(time.RFC3339))
+	}
+
+	d.add(checkResult{
+		Stage:  "OAuth",
+		Status: statusPass,
+		Detail: detail,
+	})
+
+	return true
+}
+
+func (d *doctor) checkJWT() bool {
+	token, err := d.options.TokenClient.GetJWT()
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "NATS JWT",
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   tokenErrorHint(err),
+		})
+
+		return false
+	}
+
+	info, err := connect.IntrospectJWT(token)
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "NATS JWT",
+			Status: statusFail,
+			Detail: fmt.Sprintf("the token isn't a valid NATS user JWT: %v", err),
+			Hint:   "Check that the token source is configured with a NATS user JWT",
+		})
+
+		return false
+	}
+
+	detail := fmt.Sprintf("user %v in account %v", info.Subject, info.Account)
+
+	if info.Expires != nil {
+		if time.Now().After(*info.Expires) {
+			d.add(checkResult{
+				Stage:  "NATS JWT",
+				Status: statusFail,
+				Detail: fmt.Sprintf("%v expired at %v", detail, info.Expires.Format(time.RFC3339)),
+				Hint:   "Get a new token. If it was only just issued, check that this machine's clock is correct",
+			})
+
+			return false
+		}
+
+		detail = fmt.Sprintf("%v, valid until %v", detail, info.Expires.Format(time.RFC3339))
+	}
+
+	d.add(checkResult{
+		Stage:  "NATS JWT",
+		Status: statusPass,
+		Detail: detail,
+	})
+
+	return true
+}
+
+// checkConnect Connects using the options and measures the round trip time
+func (d *doctor) checkConnect(reachable bool, tokenOK bool) {
+	if !reachable {
+		d.skip("NATS CONNECT", "", "no server was reachable")
+		d.skip("RTT", "", "no server was reachable")
+
+		return
+	}
+
+	if !tokenOK {
+		d.skip("NATS CONNECT", "", "no NATS token is available")
+		d.skip("RTT", "", "no NATS token is available")
+
+		return
+	}
+
+	servers, opts := d.options.ToNatsOptions()
+	opts = append(opts, nats.NoReconnect())
+
+	nc, err := nats.Connect(servers, opts...)
+
+	if err != nil {
+		hint := "Check the network stages above"
+
+		if connect.IsAuthError(err) {
+			hint = "NATS rejected the token. Check that the server trusts the account that issued it, and that the token hasn't been revoked"
+		}
+
+		d.add(checkResult{
+			Stage:  "NATS CONNECT",
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   hint,
+		})
+
+		d.skip("RTT", "", "couldn't connect")
+
+		return
+	}
+
+	defer nc.Close()
+
+	d.add(checkResult{
+		Stage:  "NATS CONNECT",
+		Target: nc.ConnectedUrlRedacted(),
+		Status: statusPass,
+		Detail: fmt.Sprintf("connected to server %v running NATS %v", nc.ConnectedServerId(), nc.ConnectedServerVersion()),
+	})
+
+	rtt, err := nc.RTT()
+
+	if err != nil {
+		d.add(checkResult{
+			Stage:  "RTT",
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   "The connection was lost straight after connecting. Check for proxies or firewalls that close idle or long-lived connections",
+		})
+
+		return
+	}
+
+	if rtt > rttWarningThreshold {
+		d.add(checkResult{
+			Stage:  "RTT",
+			Status: statusWarn,
+			Detail: rtt.String(),
+			Hint:   "The round trip time is high, which will slow down queries. Use a server closer to this machine if possible",
+		})
+
+		return
+	}
+
+	d.add(checkResult{
+		Stage:  "RTT",
+		Status: statusPass,
+		Detail: rtt.String(),
+	})
+}
+
+func (d *doctor) checkPermissions(tokenOK bool) {
+	if len(d.publish) == 0 && len(d.subscribe) == 0 {
+		d.skip("Permissions", "", "no subjects to check, use -publish and -subscribe")
+		return
+	}
+
+	if !tokenOK {
+		d.skip("Permissions", "", "no NATS token is available")
+		return
+	}
+
+	err := connect.CheckPermissions(d.options.TokenClient, d.publish, d.subscribe)
+
+	if err != nil {
+		hint := "The token's permissions couldn't be read. Check the NATS JWT stage above"
+
+		var permErr connect.PermissionsError
+
+		if errors.As(err, &permErr) {
+			hint = "The token doesn't allow these subjects. Check that the token is for the right account, and that its permissions include them"
+		}
+
+		d.add(checkResult{
+			Stage:  "Permissions",
+			Status: statusFail,
+			Detail: err.Error(),
+			Hint:   hint,
+		})
+
+		return
+	}
+
+	d.add(checkResult{
+		Stage:  "Permissions",
+		Status: statusPass,
+		Detail: fmt.Sprintf("%v publish and %v subscribe subjects allowed", len(d.publish), len(d.subscribe)),
+	})
+}
+
+// tokenErrorHint Suggests how to fix an error from getting a token
+func tokenErrorHint(err error) string {
+	var tokenErr *connect.TokenError
+
+	step := connect.TokenStepOAuth
+
+	if errors.As(err, &tokenErr) {
+		step = tokenErr.Step
+	}
+
+	switch {
+	case errors.Is(err, connect.ErrUnauthorized):
+		if step == connect.TokenStepOAuth {
+			return "Check the client ID and secret, or log in again"
+		}
+
+		return "The API rejected the access token. Check that -audience matches the API"
+	case errors.Is(err, connect.ErrForbidden):
+		return "The client isn't allowed to get NATS tokens for this account. Check its permissions, and only use -account with admin clients"
+	case errors.Is(err, connect.ErrAccountNotFound):
+		return "Check the account name"
+	case errors.Is(err, connect.ErrServerUnavailable):
+		if step == connect.TokenStepOAuth {
+			return "Check that -auth-url is correct and reachable from this machine"
+		}
+
+		return "Check that -api-url is correct and reachable from this machine"
+	case errors.Is(err, connect.ErrCircuitOpen):
+		return "Recent requests to the API failed, so it won't be tried again for a while. Check that -api-url is correct and reachable from this machine"
+	case errors.Is(err, connect.ErrSubjectMismatch):
+		return "The token was issued for a different NKey. Check that the token and the NKey seed belong together"
+	case errors.Is(err, connect.ErrUntrustedIssuer):
+		return "The token was issued by an account that isn't trusted. Check that the token is for the right environment"
+	}
+
+	return "Check the token source configuration, and that anything it uses is reachable from this machine"
+}
+
+// defaultPorts The URL schemes that nats.go supports, and the port that each
+// uses by default
+var defaultPorts = map[string]string{
+	"nats": "4222",
+	"tls":  "4222",
+	"ws":   "80",
+	"wss":  "443",
+}
+
+// parseServerURL Parses a NATS server URL, adding the default scheme and port
+// in the same way as nats.go
+func parseServerURL(server string) (*url.URL, error) {
+	if !strings.Contains(server, "://") {
+		server = "nats://" + server
+	}
+
+	u, err := url.Parse(server)
+
+	if err != nil {
+		return nil, err
+	}
+
+	port, ok := defaultPorts[u.Scheme]
+
+	if !ok {
+		return nil, fmt.Errorf("%v has an unsupported scheme %q", server, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%v has no hostname", server)
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	return u, nil
+}
+
+// readINFO Reads the INFO message that NATS servers send when a client
+// connects
+func readINFO(r io.Reader) (*natsInfo, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSpace(line)
+
+	if !strings.HasPrefix(line, "INFO ") {
+		return nil, fmt.Errorf("unexpected message %q", line)
+	}
+
+	body := strings.TrimPrefix(line, "INFO ")
+
+	var info natsInfo
+
+	err = json.Unmarshal([]byte(body), &info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// tlsVersionName Returns the name of a TLS version, e.g. "TLS 1.3"
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
+
+// certificateNames Returns the names that a certificate is valid for
+func certificateNames(cert *x509.Certificate) string {
+	names := append([]string{}, cert.DNSNames...)
+
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	if len(names) == 0 {
+		return cert.Subject.CommonName
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// printResults Prints the results as a table, with hints under any that
+// didn't pass
+func printResults(w io.Writer, results []checkResult) {
+	counts := make(map[checkStatus]int)
+
+	for _, r := range results {
+		counts[r.Status]++
+
+		detail := r.Detail
+
+		if r.Target != "" {
+			detail = fmt.Sprintf("%v: %v", r.Target, r.Detail)
+		}
+
+		fmt.Fprintf(w, "%-4v  %-12v %v\n", strings.ToUpper(string(r.Status)), r.Stage, detail)
+
+		if r.Hint != "" {
+			fmt.Fprintf(w, "      %-12v hint: %v\n", "", r.Hint)
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%v passed, %v warnings, %v failed, %v skipped\n", counts[statusPass], counts[statusWarn], counts[statusFail], counts[statusSkip])
+}
+
+// splitList Splits a comma separated flag into its values
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	values := strings.Split(s, ",")
+
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+
+	return values
+}
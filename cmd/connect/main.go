@@ -0,0 +1,106 @@
+// Command connect Gets NATS tokens from Overmind and inspects them. Run
+// `connect help` for usage
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// command A subcommand of the CLI
+type command struct {
+	// A one line description for the usage message
+	summary string
+	// Runs the command with the arguments after its name, returning the exit
+	// code
+	run func(args []string, stdout io.Writer, stderr io.Writer) int
+}
+
+// commands The available subcommands, by name
+var commands = map[string]command{
+	"doctor": {
+		summary: "Check each stage of connecting to NATS",
+		run:     doctorCommand,
+	},
+	"pub": {
+		summary: "Publish a message, encoding SDP messages from JSON",
+		run:     pubCommand,
+	},
+	"record": {
+		summary: "Record messages with their headers and timing to a file",
+		run:     recordCommand,
+	},
+	"replay": {
+		summary: "Replay a recording at its original or an accelerated speed",
+		run:     replayCommand,
+	},
+	"request": {
+		summary: "Send a request or SDP query and print the responses",
+		run:     requestCommand,
+	},
+	"sub": {
+		summary: "Subscribe to a subject and print messages, decoding SDP messages",
+		run:     subCommand,
+	},
+	"token": {
+		summary: "Get a NATS token and print its claims",
+		run:     tokenCommand,
+	},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run Runs the subcommand named in `args`, returning the exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	// The library logs at info level, which is too noisy for a CLI
+	log.SetOutput(stderr)
+	log.SetLevel(log.WarnLevel)
+
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(stdout)
+		return 0
+	}
+
+	cmd, ok := commands[args[0]]
+
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		usage(stderr)
+
+		return 2
+	}
+
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: connect <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10v %v\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `connect <command> -h` for the flags of each command")
+}
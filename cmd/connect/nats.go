@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+)
+
+// natsFlags The flags that choose which NATS servers to connect to
+type natsFlags struct {
+	servers string
+	name    string
+	timeout time.Duration
+}
+
+func (n *natsFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&n.servers, "server", os.Getenv("NATS_URL"), "Comma separated NATS server `URLs` (env NATS_URL)")
+	flags.StringVar(&n.name, "name", "connect-cli", "The NATS connection `name`")
+	flags.DurationVar(&n.timeout, "timeout", 5*time.Second, "How long to wait for each network operation")
+}
+
+// options Returns the options to connect using `client`. Only one attempt is
+// made to connect, so that problems are reported quickly
+func (n *natsFlags) options(client connect.TokenClient) (connect.NATSOptions, error) {
+	if n.servers == "" {
+		return connect.NATSOptions{}, errors.New("-server is required")
+	}
+
+	servers := strings.Split(n.servers, ",")
+
+	for i := range servers {
+		servers[i] = strings.TrimSpace(servers[i])
+	}
+
+	return connect.NATSOptions{
+		Servers:           servers,
+		ConnectionName:    n.name,
+		TokenClient:       client,
+		ConnectionTimeout: n.timeout,
+		MaxReconnects:     1,
+	}, nil
+}
+
+// connect Connects to NATS using `client`
+func (n *natsFlags) connect(client connect.TokenClient) (*nats.Conn, error) {
+	o, err := n.options(client)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		return nil, fmt.Errorf("connecting to NATS failed: %w", err)
+	}
+
+	return conn.Underlying(), nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/sdp-go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// messageFlags The flags shared by the commands that send and receive
+// messages
+type messageFlags struct {
+	auth       authFlags
+	nats       natsFlags
+	typeName   string
+	jsonIndent bool
+}
+
+func (m *messageFlags) register(flags *flag.FlagSet) {
+	m.auth.register(flags)
+	m.nats.register(flags)
+
+	flags.StringVar(&m.typeName, "type", "", "The SDP message `type` e.g. Query, instead of the one for the subject")
+	flags.BoolVar(&m.jsonIndent, "indent", false, "Indent the JSON output")
+}
+
+// parseMessageFlags Parses the flags and returns the remaining arguments, or
+// the exit code if the command should exit
+func parseMessageFlags(flags *flag.FlagSet, args []string, minArgs int, maxArgs int) ([]string, int, bool) {
+	err := flags.Parse(args)
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, 0, false
+		}
+
+		return nil, 2, false
+	}
+
+	if flags.NArg() < minArgs || flags.NArg() > maxArgs {
+		flags.Usage()
+		return nil, 2, false
+	}
+
+	return flags.Args(), 0, true
+}
+
+// newMessageFlagSet Creates the flags for a command that takes a subject
+func newMessageFlagSet(name string, arguments string, description string, stderr io.Writer) (*flag.FlagSet, *messageFlags) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: connect %v [flags] %v\n", name, arguments)
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, description)
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	var m messageFlags
+	m.register(flags)
+
+	return flags, &m
+}
+
+// connect Gets a token client and connects to NATS. The returned function
+// closes both
+func (m *messageFlags) connect(stderr io.Writer) (*nats.Conn, func(), error) {
+	client, _, err := m.auth.tokenClient(stderr)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nc, err := m.nats.connect(client)
+
+	if err != nil {
+		closeTokenClient(client, stderr)
+		return nil, nil, err
+	}
+
+	return nc, func() {
+		nc.Close()
+		closeTokenClient(client, stderr)
+	}, nil
+}
+
+// print Prints a message as a line of JSON, or indented JSON
+func (m *messageFlags) print(w io.Writer, msg interface{}) error {
+	encoder := json.NewEncoder(w)
+
+	if m.jsonIndent {
+		encoder.SetIndent("", "  ")
+	}
+
+	return encoder.Encode(msg)
+}
+
+// readPayload Returns the JSON payload from the arguments, or from stdin if it
+// wasn't given
+func readPayload(args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return []byte(args[1]), nil
+	}
+
+	return io.ReadAll(os.Stdin)
+}
+
+func subCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, m := newMessageFlagSet("sub", "<subject>", "Subscribes to a subject and prints each message as JSON, decoding SDP messages", stderr)
+
+	count := flags.Int("count", 0, "Exit after this many messages. Zero means run until interrupted")
+
+	args, code, ok := parseMessageFlags(flags, args, 1, 1)
+
+	if !ok {
+		return code
+	}
+
+	subject := args[0]
+
+	// Check the type now rather than failing on every message
+	_, err := messageType(subject, m.typeName)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	nc, closeConn, err := m.connect(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer closeConn()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	msgs := make(chan *nats.Msg, 1024)
+
+	sub, err := nc.ChanSubscribe(subject, msgs)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Subscribing to %v failed: %v\n", subject, err)
+		return 1
+	}
+
+	defer sub.Unsubscribe()
+
+	// Make sure that the subscription has been accepted before saying so
+	err = nc.Flush()
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Subscribing to %v failed: %v\n", subject, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "Listening on %v\n", subject)
+
+	for received := 0; *count == 0 || received < *count; received++ {
+		select {
+		case <-ctx.Done():
+			return 0
+		case msg := <-msgs:
+			err = m.print(stdout, decodeMessage(msg, m.typeName))
+
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
+
+func pubCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, m := newMessageFlagSet("pub", "<subject> [json]", "Publishes a message to a subject. On SDP subjects the JSON is encoded as the SDP message for the subject. The JSON is read from stdin if it isn't given", stderr)
+
+	reply := flags.String("reply", "", "The `subject` for replies")
+
+	args, code, ok := parseMessageFlags(flags, args, 1, 2)
+
+	if !ok {
+		return code
+	}
+
+	subject := args[0]
+
+	payload, err := readPayload(args)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	data, message, err := encodeMessage(subject, m.typeName, payload)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	nc, closeConn, err := m.connect(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer closeConn()
+
+	err = nc.PublishMsg(&nats.Msg{
+		Subject: subject,
+		Reply:   *reply,
+		Data:    data,
+	})
+
+	if err == nil {
+		err = nc.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Publishing to %v failed: %v\n", subject, err)
+		return 1
+	}
+
+	if q, ok := message.(*sdp.Query); ok {
+		fmt.Fprintf(stderr, "Published query %v, responses will be sent to %v\n", q.ParseUuid(), q.Subject())
+	}
+
+	return 0
+}
+
+func requestCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, m := newMessageFlagSet("request", "<subject> [json]", "Sends a request and prints the responses as JSON. SDP queries print every QueryResponse until -wait has passed, other requests print the first reply. The JSON is read from stdin if it isn't given", stderr)
+
+	wait := flags.Duration("wait", 10*time.Second, "How long to wait for responses")
+	count := flags.Int("count", 0, "Exit after this many query responses. Zero means wait for the whole of -wait")
+	responseType := flags.String("response-type", "", "The SDP message `type` of the reply to a request that isn't a query")
+
+	args, code, ok := parseMessageFlags(flags, args, 1, 2)
+
+	if !ok {
+		return code
+	}
+
+	subject := args[0]
+
+	payload, err := readPayload(args)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	data, message, err := encodeMessage(subject, m.typeName, payload)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// Responders stop working on a query once its timeout has passed
+	if q, ok := message.(*sdp.Query); ok && q.Timeout == nil {
+		q.Timeout = durationpb.New(*wait)
+
+		data, err = proto.Marshal(q)
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+
+	nc, closeConn, err := m.connect(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer closeConn()
+
+	if q, ok := message.(*sdp.Query); ok {
+		return m.query(nc, subject, q, data, *wait, *count, stdout, stderr)
+	}
+
+	reply, err := nc.Request(subject, data, *wait)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Request to %v failed: %v\n", subject, err)
+		return 1
+	}
+
+	err = m.print(stdout, decodeMessage(reply, *responseType))
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// query Publishes a query and prints the responses, which SDP sends on the
+// query's own subject rather than as replies
+func (m *messageFlags) query(nc *nats.Conn, subject string, q *sdp.Query, data []byte, wait time.Duration, count int, stdout io.Writer, stderr io.Writer) int {
+	msgs := make(chan *nats.Msg, 1024)
+
+	sub, err := nc.ChanSubscribe(q.Subject(), msgs)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Subscribing to %v failed: %v\n", q.Subject(), err)
+		return 1
+	}
+
+	defer sub.Unsubscribe()
+
+	err = nc.Publish(subject, data)
+
+	if err == nil {
+		err = nc.Flush()
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Publishing to %v failed: %v\n", subject, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	deadline := time.After(wait)
+
+	for received := 0; count == 0 || received < count; received++ {
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-deadline:
+			if received == 0 {
+				fmt.Fprintf(stderr, "No responses to query %v within %v\n", q.ParseUuid(), wait)
+				return 1
+			}
+
+			return 0
+		case msg := <-msgs:
+			err = m.print(stdout, decodeMessage(msg, ""))
+
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return 1
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect/connecttest"
+	"github.com/overmindtech/sdp-go"
+)
+
+const testQueryJSON = `{"type": "person", "method": "GET", "query": "dylan", "scope": "test"}`
+
+// connectResponder Connects to the server directly, for tests to respond to
+// the CLI
+func connectResponder(t *testing.T, s *connecttest.Server) sdp.EncodedConnection {
+	t.Helper()
+
+	o, err := s.NATSOptions(connecttest.UserConfig{Name: "responder"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(ec.Close)
+
+	return ec
+}
+
+func TestSubAndPubCommands(t *testing.T) {
+	clearAuthEnv(t)
+
+	s := connecttest.Start(t)
+	api := connecttest.StartAPI(t, s)
+	authArgs := append([]string{"-server", s.URL}, testAuthArgs(api)...)
+
+	var stdout, stderr bytes.Buffer
+
+	done := make(chan int)
+
+	go func() {
+		done <- run(append(append([]string{"sub", "-count", "1"}, authArgs...), "request.scope.test"), &stdout, &stderr)
+	}()
+
+	// Keep publishing until the subscriber has started and received one
+	var code int
+
+	for received := false; !received; {
+		var pubOut, pubErr bytes.Buffer
+
+		if c := run(append(append([]string{"pub"}, authArgs...), "request.scope.test", testQueryJSON), &pubOut, &pubErr); c != 0 {
+			t.Fatalf("expected exit code 0 from pub, got %v: %v", c, pubErr.String())
+		}
+
+		select {
+		case code = <-done:
+			received = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if code != 0 {
+		t.Fatalf("expected exit code 0 from sub, got %v: %v", code, stderr.String())
+	}
+
+	var msg struct {
+		Subject string
+		Type    string
+		Data    map[string]interface{}
+	}
+
+	err := json.Unmarshal(stdout.Bytes(), &msg)
+
+	if err != nil {
+		t.Fatalf("parsing %v failed: %v", stdout.String(), err)
+	}
+
+	if msg.Subject != "request.scope.test" || msg.Type != "Query" {
+		t.Errorf("expected a Query on request.scope.test, got %v", stdout.String())
+	}
+
+	if msg.Data["query"] != "dylan" || msg.Data["UUID"] == nil {
+		t.Errorf("expected the query with a UUID, got %v", msg.Data)
+	}
+
+	t.Run("with invalid JSON", func(t *testing.T) {
+		if code := run(append(append([]string{"pub"}, authArgs...), "request.all", "{"), &stdout, &stderr); code != 2 {
+			t.Errorf("expected exit code 2, got %v", code)
+		}
+	})
+
+	t.Run("without a subject", func(t *testing.T) {
+		if code := run(append([]string{"sub"}, authArgs...), &stdout, &stderr); code != 2 {
+			t.Errorf("expected exit code 2, got %v", code)
+		}
+	})
+}
+
+func TestRequestCommand(t *testing.T) {
+	clearAuthEnv(t)
+
+	s := connecttest.Start(t)
+	api := connecttest.StartAPI(t, s)
+	authArgs := append([]string{"-server", s.URL}, testAuthArgs(api)...)
+
+	ec := connectResponder(t, s)
+
+	_, err := ec.Subscribe("request.scope.test", sdp.NewQueryHandler("test", func(ctx context.Context, q *sdp.Query) {
+		ec.Publish(ctx, q.Subject(), &sdp.QueryResponse{
+			ResponseType: &sdp.QueryResponse_Response{
+				Response: &sdp.Response{
+					Responder: "test-responder",
+					State:     sdp.ResponderState_COMPLETE,
+					UUID:      q.UUID,
+				},
+			},
+		})
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ec.Subscribe("echo", func(msg *nats.Msg) {
+		msg.Respond(msg.Data)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ec.Underlying().Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("a query", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(append(append([]string{"request", "-count", "1", "-wait", "5s"}, authArgs...), "request.scope.test", testQueryJSON), &stdout, &stderr)
+
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %v: %v", code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), `"type":"QueryResponse"`) || !strings.Contains(stdout.String(), "test-responder") {
+			t.Errorf("expected the decoded response, got %v", stdout.String())
+		}
+	})
+
+	t.Run("a query with no responders", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(append(append([]string{"request", "-wait", "200ms"}, authArgs...), "request.scope.nobody", testQueryJSON), &stdout, &stderr)
+
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %v", code)
+		}
+
+		if !strings.Contains(stderr.String(), "No responses") {
+			t.Errorf("expected an error, got %v", stderr.String())
+		}
+	})
+
+	t.Run("a plain request", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run(append(append([]string{"request"}, authArgs...), "echo", `{"hello":"world"}`), &stdout, &stderr)
+
+		if code != 0 {
+			t.Fatalf("expected exit code 0, got %v: %v", code, stderr.String())
+		}
+
+		if !strings.Contains(stdout.String(), `"data":{"hello":"world"}`) {
+			t.Errorf("expected the reply, got %v", stdout.String())
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+)
+
+// listedMessage A message from a recording, for printing with `replay -list`
+type listedMessage struct {
+	Offset string `json:"offset"`
+	decodedMessage
+}
+
+func recordCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: connect record [flags] -o <file> <subject>...")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Records every message on the subjects, with headers and timing, until interrupted. Use `connect replay` to replay the recording")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	var auth authFlags
+	auth.register(flags)
+
+	var natsConfig natsFlags
+	natsConfig.register(flags)
+
+	output := flags.String("o", "", "The `file` to write the recording to")
+	count := flags.Int("count", 0, "Stop after recording this many messages")
+	duration := flags.Duration("duration", 0, "Stop after recording for this long")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 2
+	}
+
+	if *output == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	client, _, err := auth.tokenClient(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	defer closeTokenClient(client, stderr)
+
+	nc, err := natsConfig.connect(client)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer nc.Close()
+
+	file, err := os.Create(*output)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer file.Close()
+
+	recorder, err := connect.NewRecorder(nc, file, connect.RecorderOptions{Limit: *count}, flags.Args()...)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Starting recording failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "Recording %v to %v\n", flags.Args(), *output)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-recorder.Done():
+	}
+
+	err = recorder.Close()
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Writing recording failed: %v\n", err)
+		return 1
+	}
+
+	err = file.Close()
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Writing recording failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "Recorded %v messages\n", recorder.Count())
+
+	return 0
+}
+
+func replayCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags, m := newMessageFlagSet("replay", "<file>", "Publishes the messages in a recording made by `connect record`, keeping the original timing", stderr)
+
+	speed := flags.Float64("speed", 1, "How many times faster than the original to replay. 0 replays as fast as possible")
+	keepReplies := flags.Bool("keep-replies", false, "Keep the recorded reply subjects")
+	list := flags.Bool("list", false, "Print the messages as JSON instead of replaying them, decoding SDP messages")
+
+	args, code, ok := parseMessageFlags(flags, args, 1, 1)
+
+	if !ok {
+		return code
+	}
+
+	if *speed < 0 {
+		fmt.Fprintln(stderr, "-speed can't be negative")
+		return 2
+	}
+
+	file, err := os.Open(args[0])
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer file.Close()
+
+	if *list {
+		err = m.list(file, stdout)
+
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		return 0
+	}
+
+	nc, closeConn, err := m.connect(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	defer closeConn()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	count, err := connect.Replay(ctx, nc, file, connect.ReplayOptions{
+		Speed:       *speed,
+		KeepReplies: *keepReplies,
+	})
+
+	fmt.Fprintf(stderr, "Replayed %v messages\n", count)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Replaying failed: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// list Prints every message in a recording
+func (m *messageFlags) list(r io.Reader, w io.Writer) error {
+	reader, err := connect.NewRecordingReader(r)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		recorded, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		decoded := decodeMessage(&nats.Msg{
+			Subject: recorded.Subject,
+			Reply:   recorded.Reply,
+			Header:  recorded.Header,
+			Data:    recorded.Data,
+		}, m.typeName)
+
+		err = m.print(w, listedMessage{
+			Offset:         recorded.Offset.String(),
+			decodedMessage: decoded,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+}
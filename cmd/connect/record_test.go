@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestRecordAndReplayCommands(t *testing.T) {
+	clearAuthEnv(t)
+
+	s := connecttest.Start(t)
+	api := connecttest.StartAPI(t, s)
+	authArgs := append([]string{"-server", s.URL}, testAuthArgs(api)...)
+	recording := filepath.Join(t.TempDir(), "traffic.rec")
+
+	var stdout, stderr bytes.Buffer
+
+	done := make(chan int)
+
+	go func() {
+		done <- run(append(append([]string{"record", "-count", "2", "-o", recording}, authArgs...), "request.>"), &stdout, &stderr)
+	}()
+
+	// Keep publishing until the recorder has started and recorded enough
+	var code int
+
+	for recorded := false; !recorded; {
+		var pubOut, pubErr bytes.Buffer
+
+		if c := run(append(append([]string{"pub"}, authArgs...), "request.scope.test", testQueryJSON), &pubOut, &pubErr); c != 0 {
+			t.Fatalf("expected exit code 0 from pub, got %v: %v", c, pubErr.String())
+		}
+
+		select {
+		case code = <-done:
+			recorded = true
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if code != 0 {
+		t.Fatalf("expected exit code 0 from record, got %v: %v", code, stderr.String())
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+
+		if code := run([]string{"replay", "-list", recording}, &out, &errOut); code != 0 {
+			t.Fatalf("expected exit code 0, got %v: %v", code, errOut.String())
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+		if len(lines) < 2 {
+			t.Fatalf("expected at least 2 messages, got %v", out.String())
+		}
+
+		var msg listedMessage
+
+		err := json.Unmarshal([]byte(lines[0]), &msg)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if msg.Subject != "request.scope.test" || msg.Type != "Query" || msg.Offset == "" {
+			t.Errorf("expected a decoded query with an offset, got %v", lines[0])
+		}
+	})
+
+	t.Run("replaying", func(t *testing.T) {
+		ec := connectResponder(t, s)
+
+		sub, err := ec.Underlying().SubscribeSync("request.>")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ec.Underlying().Flush()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out, errOut bytes.Buffer
+
+		if code := run(append(append([]string{"replay", "-speed", "0"}, authArgs...), recording), &out, &errOut); code != 0 {
+			t.Fatalf("expected exit code 0, got %v: %v", code, errOut.String())
+		}
+
+		for i := 0; i < 2; i++ {
+			msg, err := sub.NextMsg(5 * time.Second)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.Subject != "request.scope.test" {
+				t.Errorf("expected the recorded subject, got %v", msg.Subject)
+			}
+		}
+	})
+
+	t.Run("without an output file", func(t *testing.T) {
+		if code := run(append(append([]string{"record"}, authArgs...), "request.>"), &stdout, &stderr); code != 2 {
+			t.Errorf("expected exit code 2, got %v", code)
+		}
+	})
+
+	t.Run("a file that isn't a recording", func(t *testing.T) {
+		if code := run([]string{"replay", "-list", "record_test.go"}, &stdout, &stderr); code != 1 {
+			t.Errorf("expected exit code 1, got %v", code)
+		}
+	})
+}
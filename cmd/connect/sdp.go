@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/sdp-go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// sdpSubject A NATS subject pattern used by SDP, and the type of message that
+// is sent on it
+type sdpSubject struct {
+	pattern string
+	message string
+}
+
+// sdpSubjects The subjects that SDP uses. Scopes can contain dots e.g.
+// `123456.eu-west-2`, so they are matched with `>`. Messages on other subjects
+// can be decoded by giving their type explicitly
+var sdpSubjects = []sdpSubject{
+	{pattern: "request.all", message: "Query"},
+	{pattern: "request.scope.>", message: "Query"},
+	{pattern: "query.*", message: "QueryResponse"},
+	{pattern: "cancel.all", message: "CancelQuery"},
+	{pattern: "cancel.scope.>", message: "CancelQuery"},
+}
+
+// decodedMessage A NATS message with its payload decoded for printing. The
+// payload is in exactly one of `Data`, `Text` or `Raw`
+type decodedMessage struct {
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply,omitempty"`
+	Headers nats.Header `json:"headers,omitempty"`
+	// The protobuf message type, if the payload was decoded as one
+	Type string `json:"type,omitempty"`
+	// The payload as JSON, if it is an SDP message or already JSON
+	Data json.RawMessage `json:"data,omitempty"`
+	// The payload, if it is text but not JSON
+	Text string `json:"text,omitempty"`
+	// The payload, if it is binary
+	Raw []byte `json:"raw,omitempty"`
+	// Why the payload couldn't be decoded as `Type`
+	Error string `json:"error,omitempty"`
+}
+
+// messageType Returns the protobuf type to use for a message on `subject`.
+// `name` overrides the type that is looked up from the subject. Returns nil if
+// the subject isn't a known SDP subject and no name was given
+func messageType(subject string, name string) (protoreflect.MessageType, error) {
+	if name == "" {
+		for _, s := range sdpSubjects {
+			if subjectMatches(s.pattern, subject) {
+				name = s.message
+				break
+			}
+		}
+	}
+
+	if name == "" {
+		return nil, nil
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+
+	if err != nil {
+		return nil, fmt.Errorf("unknown message type %q", name)
+	}
+
+	return mt, nil
+}
+
+// decodeMessage Decodes a message received on NATS. Payloads that can't be
+// decoded are still returned, along with the reason
+func decodeMessage(msg *nats.Msg, typeName string) decodedMessage {
+	decoded := decodedMessage{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+	}
+
+	if len(msg.Header) > 0 {
+		decoded.Headers = msg.Header
+	}
+
+	mt, err := messageType(msg.Subject, typeName)
+
+	if err != nil {
+		decoded.Error = err.Error()
+	} else if mt != nil {
+		decoded.Type = string(mt.Descriptor().FullName())
+
+		data, err := unmarshalSDP(msg.Data, mt)
+
+		if err == nil {
+			decoded.Data = data
+			return decoded
+		}
+
+		decoded.Error = err.Error()
+	}
+
+	switch {
+	case json.Valid(msg.Data):
+		decoded.Data = msg.Data
+	case utf8.Valid(msg.Data):
+		decoded.Text = string(msg.Data)
+	default:
+		decoded.Raw = msg.Data
+	}
+
+	return decoded
+}
+
+// unmarshalSDP Decodes a protobuf message of type `mt` and returns it as JSON
+func unmarshalSDP(data []byte, mt protoreflect.MessageType) (json.RawMessage, error) {
+	m := mt.New().Interface()
+
+	err := proto.Unmarshal(data, m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding the wrong type often partially succeeds, leaving unknown fields
+	if len(m.ProtoReflect().GetUnknown()) > 0 {
+		return nil, fmt.Errorf("the message has fields that %v doesn't, so it is probably a different type", mt.Descriptor().FullName())
+	}
+
+	return protojson.Marshal(m)
+}
+
+// encodeMessage Encodes JSON as the protobuf message for `subject`, or for
+// `typeName` if it is set. Queries without a UUID are given a new one. If the
+// subject isn't a known SDP subject, the JSON is returned as it is, and the
+// returned message is nil
+func encodeMessage(subject string, typeName string, data []byte) ([]byte, proto.Message, error) {
+	mt, err := messageType(subject, typeName)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mt == nil {
+		return data, nil, nil
+	}
+
+	m := mt.New().Interface()
+
+	err = protojson.Unmarshal(data, m)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %v failed: %w", mt.Descriptor().FullName(), err)
+	}
+
+	if q, ok := m.(*sdp.Query); ok && len(q.UUID) == 0 {
+		u := uuid.New()
+		q.UUID = u[:]
+	}
+
+	encoded, err := proto.Marshal(m)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encoded, m, nil
+}
+
+// subjectMatches Returns true if the literal `subject` is matched by
+// `pattern`, which may contain the `*` and `>` wildcards
+func subjectMatches(pattern string, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, patternToken := range patternTokens {
+		if patternToken == ">" {
+			return i < len(subjectTokens)
+		}
+
+		if i >= len(subjectTokens) {
+			return false
+		}
+
+		if patternToken != "*" && patternToken != subjectTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
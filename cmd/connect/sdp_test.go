@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/sdp-go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		subject string
+		matches bool
+	}{
+		{"request.all", "request.all", true},
+		{"request.all", "request.scope", false},
+		{"request.scope.*", "request.scope.prod", true},
+		{"request.scope.*", "request.scope.prod.eu", false},
+		{"request.scope.*", "request.scope", false},
+		{"query.>", "query.a.b", true},
+		{"query.>", "query", false},
+	}
+
+	for _, test := range tests {
+		if got := subjectMatches(test.pattern, test.subject); got != test.matches {
+			t.Errorf("expected subjectMatches(%v, %v) to be %v, got %v", test.pattern, test.subject, test.matches, got)
+		}
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	t.Run("a query", func(t *testing.T) {
+		data, message, err := encodeMessage("request.scope.test", "", []byte(`{"type": "person", "method": "GET", "query": "dylan", "scope": "test"}`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q, ok := message.(*sdp.Query)
+
+		if !ok {
+			t.Fatalf("expected a Query, got %T", message)
+		}
+
+		if len(q.UUID) != 16 {
+			t.Errorf("expected a UUID to be generated, got %v", q.UUID)
+		}
+
+		var decoded sdp.Query
+
+		err = proto.Unmarshal(data, &decoded)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Query != "dylan" || decoded.Method != sdp.QueryMethod_GET {
+			t.Errorf("expected the encoded query to match, got %v", &decoded)
+		}
+	})
+
+	t.Run("a query for a scope containing dots", func(t *testing.T) {
+		subject := "request.scope.123456.eu-west-2"
+
+		data, message, err := encodeMessage(subject, "", []byte(`{"type": "ec2-instance", "method": "LIST", "scope": "123456.eu-west-2"}`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := message.(*sdp.Query); !ok {
+			t.Errorf("expected a Query, got %T", message)
+		}
+
+		if decoded := decodeMessage(&nats.Msg{Subject: subject, Data: data}, ""); decoded.Type != "Query" || decoded.Error != "" {
+			t.Errorf("expected the query to be decoded, got %+v", decoded)
+		}
+
+		mt, err := messageType("cancel.scope.123456.eu-west-2", "")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mt == nil || mt.Descriptor().FullName() != "CancelQuery" {
+			t.Errorf("expected CancelQuery, got %v", mt)
+		}
+	})
+
+	t.Run("an explicit type", func(t *testing.T) {
+		_, message, err := encodeMessage("custom", "Reference", []byte(`{"type": "person"}`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := message.(*sdp.Reference); !ok {
+			t.Errorf("expected a Reference, got %T", message)
+		}
+	})
+
+	t.Run("an unknown subject", func(t *testing.T) {
+		data, message, err := encodeMessage("custom", "", []byte(`{"hello": "world"}`))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if message != nil || string(data) != `{"hello": "world"}` {
+			t.Errorf("expected the payload to be unchanged, got %v", string(data))
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		_, _, err := encodeMessage("request.all", "", []byte(`{"notAField": true}`))
+
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("an unknown type", func(t *testing.T) {
+		_, _, err := encodeMessage("custom", "NotAType", []byte(`{}`))
+
+		if err == nil || !strings.Contains(err.Error(), "NotAType") {
+			t.Errorf("expected an unknown type error, got %v", err)
+		}
+	})
+}
+
+func TestDecodeMessage(t *testing.T) {
+	response, err := proto.Marshal(&sdp.QueryResponse{
+		ResponseType: &sdp.QueryResponse_Response{
+			Response: &sdp.Response{
+				Responder: "test-responder",
+				State:     sdp.ResponderState_COMPLETE,
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("an SDP message", func(t *testing.T) {
+		msg := &nats.Msg{
+			Subject: "query.2ed7d0f2-b6f5-4f28-9c50-3c3d4a5e9a0b",
+			Data:    response,
+			Header:  nats.Header{"Traceparent": []string{"00-abc"}},
+		}
+
+		decoded := decodeMessage(msg, "")
+
+		if decoded.Type != "QueryResponse" {
+			t.Errorf("expected type QueryResponse, got %v", decoded.Type)
+		}
+
+		var data map[string]interface{}
+
+		err := json.Unmarshal(decoded.Data, &data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ := data["response"].(map[string]interface{})
+
+		if r["responder"] != "test-responder" || r["state"] != "COMPLETE" {
+			t.Errorf("expected the response to be decoded, got %v", string(decoded.Data))
+		}
+
+		if decoded.Headers.Get("Traceparent") != "00-abc" {
+			t.Errorf("expected headers to be kept, got %v", decoded.Headers)
+		}
+	})
+
+	t.Run("the wrong type", func(t *testing.T) {
+		decoded := decodeMessage(&nats.Msg{Subject: "custom", Data: []byte("not protobuf")}, "Query")
+
+		if decoded.Error == "" {
+			t.Error("expected an error")
+		}
+
+		if decoded.Text != "not protobuf" {
+			t.Errorf("expected the payload as text, got %v", decoded.Text)
+		}
+	})
+
+	t.Run("other payloads", func(t *testing.T) {
+		if decoded := decodeMessage(&nats.Msg{Subject: "custom", Data: []byte(`{"a":1}`)}, ""); string(decoded.Data) != `{"a":1}` {
+			t.Errorf("expected JSON to be kept as JSON, got %v", decoded)
+		}
+
+		if decoded := decodeMessage(&nats.Msg{Subject: "custom", Data: []byte{0xff, 0x00}}, ""); len(decoded.Raw) != 2 {
+			t.Errorf("expected binary to be kept as raw bytes, got %v", decoded)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+)
+
+// tokenCommand Gets a NATS token and prints its claims, optionally writing it
+// to a .creds file
+func tokenCommand(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("token", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: connect token [flags]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "Gets a NATS token and prints the claims in it")
+		fmt.Fprintln(stderr)
+		flags.PrintDefaults()
+	}
+
+	var auth authFlags
+	auth.register(flags)
+
+	asJSON := flags.Bool("json", false, "Print the claims as JSON")
+	credsOut := flags.String("write-creds", "", "Write the token and NKey to this `.creds file`, for use with the nats CLI")
+
+	err := flags.Parse(args)
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 2
+	}
+
+	client, keys, err := auth.tokenClient(stderr)
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	defer closeTokenClient(client, stderr)
+
+	token, err := client.GetJWT()
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Getting NATS token failed: %v\n", err)
+		return 1
+	}
+
+	info, err := connect.IntrospectJWT(token)
+
+	if err != nil {
+		fmt.Fprintf(stderr, "Decoding NATS token failed: %v\n", err)
+		return 1
+	}
+
+	if *credsOut != "" {
+		err = writeCreds(*credsOut, token, keys)
+
+		if err != nil {
+			fmt.Fprintf(stderr, "Writing %v failed: %v\n", *credsOut, err)
+			return 1
+		}
+
+		fmt.Fprintf(stderr, "Wrote credentials to %v\n", *credsOut)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(info)
+	} else {
+		err = printTokenInfo(stdout, info, time.Now())
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+// closeTokenClient Closes the token client if it can be closed
+func closeTokenClient(client connect.TokenClient, stderr io.Writer) {
+	if c, ok := client.(io.Closer); ok {
+		err := c.Close()
+
+		if err != nil {
+			fmt.Fprintf(stderr, "Closing token client failed: %v\n", err)
+		}
+	}
+}
+
+// writeCreds Writes a .creds file containing the token and the seed of the
+// NKey that it was issued for
+func writeCreds(path string, token string, keys nkeys.KeyPair) error {
+	if keys == nil {
+		return errors.New("the NKey for this token isn't available")
+	}
+
+	seed, err := keys.Seed()
+
+	if err != nil {
+		return err
+	}
+
+	creds, err := jwt.FormatUserConfig(token, seed)
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, creds, 0600)
+}
+
+// printTokenInfo Prints the claims in a human readable form
+func printTokenInfo(w io.Writer, info *connect.TokenInfo, now time.Time) error {
+	expires := "never"
+
+	if info.Expires != nil {
+		remaining := info.Expires.Sub(now).Round(time.Second)
+
+		if remaining > 0 {
+			expires = fmt.Sprintf("%v (in %v)", info.Expires.Format(time.RFC3339), remaining)
+		} else {
+			expires = fmt.Sprintf("%v (expired %v ago)", info.Expires.Format(time.RFC3339), -remaining)
+		}
+	}
+
+	lines := [][2]string{
+		{"Name", info.Name},
+		{"Subject", info.Subject},
+		{"Account", info.Account},
+		{"Issuer", info.Issuer},
+		{"Expires", expires},
+		{"Publish", formatPermissions(info.Publish)},
+		{"Subscribe", formatPermissions(info.Subscribe)},
+	}
+
+	for _, line := range lines {
+		_, err := fmt.Fprintf(w, "%-10v %v\n", line[0]+":", line[1])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// formatPermissions Describes the subjects that are allowed and denied
+func formatPermissions(p connect.SubjectPermissions) string {
+	allow := "all subjects"
+
+	if len(p.Allow) > 0 {
+		allow = strings.Join(p.Allow, ", ")
+	}
+
+	if len(p.Deny) == 0 {
+		return allow
+	}
+
+	return fmt.Sprintf("%v, except %v", allow, strings.Join(p.Deny, ", "))
+}
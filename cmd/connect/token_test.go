@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+// clearAuthEnv Makes sure that the environment doesn't change which token
+// client the flags create
+func clearAuthEnv(t *testing.T) {
+	t.Helper()
+
+	for _, name := range []string{"NATS_CREDS", "OVERMIND_AUTH_URL", "OVERMIND_API_URL", "OVERMIND_CLIENT_ID", "OVERMIND_CLIENT_SECRET", "OVERMIND_ACCOUNT"} {
+		t.Setenv(name, "")
+	}
+}
+
+// testAuthArgs Returns the flags to get a token from the fake API using the
+// client credentials flow
+func testAuthArgs(api *connecttest.APIServer) []string {
+	return []string{
+		"-auth-url", api.URL,
+		"-api-url", api.ExchangeURL,
+		"-client-id", connecttest.ClientID,
+		"-client-secret", connecttest.ClientSecret,
+	}
+}
+
+func TestTokenCommand(t *testing.T) {
+	clearAuthEnv(t)
+
+	api := connecttest.StartAPI(t, nil)
+	credsPath := filepath.Join(t.TempDir(), "user.creds")
+
+	var stdout, stderr bytes.Buffer
+
+	args := append([]string{"token", "-json", "-write-creds", credsPath}, testAuthArgs(api)...)
+
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("expected exit code 0, got %v: %v", code, stderr.String())
+	}
+
+	var info connect.TokenInfo
+
+	err := json.Unmarshal(stdout.Bytes(), &info)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Issuer != api.IssuerPublicKey() {
+		t.Errorf("expected issuer %v, got %v", api.IssuerPublicKey(), info.Issuer)
+	}
+
+	if info.Expires == nil {
+		t.Error("expected an expiry")
+	}
+
+	t.Run("reading the written creds file", func(t *testing.T) {
+		var out bytes.Buffer
+
+		if code := run([]string{"token", "-creds", credsPath}, &out, &stderr); code != 0 {
+			t.Fatalf("expected exit code 0, got %v: %v", code, stderr.String())
+		}
+
+		if !strings.Contains(out.String(), info.Subject) {
+			t.Errorf("expected output to include subject %v, got %v", info.Subject, out.String())
+		}
+	})
+
+	t.Run("with a failing API", func(t *testing.T) {
+		api.Fail(connecttest.EndpointCreateToken, 403)
+
+		var errOut bytes.Buffer
+
+		if code := run(append([]string{"token"}, testAuthArgs(api)...), &stdout, &errOut); code != 1 {
+			t.Errorf("expected exit code 1, got %v", code)
+		}
+
+		if !strings.Contains(errOut.String(), "Getting NATS token failed") {
+			t.Errorf("expected an error, got %v", errOut.String())
+		}
+	})
+
+	t.Run("without an auth method", func(t *testing.T) {
+		if code := run([]string{"token"}, &stdout, &stderr); code != 2 {
+			t.Errorf("expected exit code 2, got %v", code)
+		}
+	})
+}
+
+func TestPrintTokenInfo(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+
+	info := &connect.TokenInfo{
+		Name:    "test-user",
+		Subject: "UXXXX",
+		Account: "AXXXX",
+		Issuer:  "AYYYY",
+		Expires: &expires,
+		Publish: connect.SubjectPermissions{
+			Allow: []string{"request.>", "_INBOX.>"},
+		},
+		Subscribe: connect.SubjectPermissions{
+			Deny: []string{"secret.>"},
+		},
+	}
+
+	var out bytes.Buffer
+
+	err := printTokenInfo(&out, info, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Name:      test-user
+Subject:   UXXXX
+Account:   AXXXX
+Issuer:    AYYYY
+Expires:   2023-06-01T13:00:00Z (in 1h0m0s)
+Publish:   request.>, _INBOX.>
+Subscribe: all subjects, except secret.>
+`
+
+	if out.String() != expected {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, out.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := run(nil, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 with no command, got %v", code)
+	}
+
+	if code := run([]string{"foo"}, &stdout, &stderr); code != 2 {
+		t.Errorf("expected exit code 2 with an unknown command, got %v", code)
+	}
+
+	if code := run([]string{"help"}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), "token") {
+		t.Errorf("expected usage to list commands, got %v", stdout.String())
+	}
+}
@@ -0,0 +1,415 @@
+package connecttest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	overmind "github.com/overmindtech/api-client"
+	"github.com/overmindtech/connect"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// The client credentials that the fake OAuth server accepts
+const (
+	ClientID     = "connecttest-client"
+	ClientSecret = "connecttest-secret"
+)
+
+// Defaults
+const TokenExpiryDefault = time.Hour
+const AccessTokenExpiryDefault = time.Hour
+
+// Endpoint Identifies an endpoint of the fake API, for scripting failures and
+// counting requests
+type Endpoint string
+
+const (
+	// EndpointOAuth The OAuth token endpoint, for every grant type
+	EndpointOAuth Endpoint = "oauth"
+	// EndpointDeviceCode The OAuth device authorization endpoint
+	EndpointDeviceCode Endpoint = "device_code"
+	// EndpointAuthorize The OAuth authorization endpoint, which logs the user
+	// in straight away and redirects back with an authorization code
+	EndpointAuthorize Endpoint = "authorize"
+	// EndpointRevoke The OAuth token revocation endpoint
+	EndpointRevoke Endpoint = "revoke"
+	// EndpointCreateToken The `CreateToken` API, which exchanges an access
+	// token for a NATS JWT
+	EndpointCreateToken Endpoint = "create_token"
+	// EndpointAdminCreateToken The `AdminCreateToken` API, which does the same
+	// for an explicitly requested account
+	EndpointAdminCreateToken Endpoint = "admin_create_token"
+)
+
+// Failure A scripted response for a single request
+type Failure struct {
+	// How long to wait before responding
+	Latency time.Duration
+	// The status code to respond with. If zero, the request is handled normally
+	// after the latency
+	StatusCode int
+	// If set, a Retry-After header is sent with the failure
+	RetryAfter time.Duration
+}
+
+// Request A request that the fake API has handled
+type Request struct {
+	Endpoint Endpoint
+	// The account requested using `AdminCreateToken`
+	Account string
+	// The public key and name that a NATS JWT was requested for
+	UserPubKey string
+	UserName   string
+	// The form of an OAuth request, or the query of an authorization request
+	Form url.Values
+	// The status code of the response
+	StatusCode int
+}
+
+// APIServer A fake of the Overmind OAuth server and NATS token exchange, so
+// that every `connect` token client can be tested without any external
+// services. Responses can be delayed or made to fail using `Fail()` and
+// `SetLatency()`
+type APIServer struct {
+	// The base URL of the server
+	URL string
+	// The OAuth token endpoint, for `connect.NewOAuthTokenClient`
+	OAuthURL string
+	// The URL of the API, for `connect.NewOAuthTokenClient`
+	ExchangeURL string
+	// The OAuth device authorization endpoint, for `connect.DeviceFlowConfig`
+	DeviceAuthURL string
+	// The OAuth authorization endpoint, for `connect.AuthCodeConfig`
+	AuthURL string
+	// The OAuth token revocation endpoint
+	RevocationURL string
+
+	issuer        nkeys.KeyPair
+	issuerAccount string
+	server        *httptest.Server
+
+	mutex             sync.Mutex
+	tokenExpiry       time.Duration
+	accessTokenExpiry time.Duration
+	latency           time.Duration
+	pendingPolls      int
+	accessTokens      map[string]time.Time
+	refreshTokens     map[string]*refreshFamily
+	authorizations    map[string]*authorization
+	failures          map[Endpoint][]Failure
+	requests          []Request
+}
+
+// NewAPIServer Starts a fake API. If `nats` is supplied, JWTs are signed with
+// its account's signing key so that they can be used to connect to it.
+// Otherwise a new account key is generated. The server must be closed using
+// `Close()`
+func NewAPIServer(nats *Server) (*APIServer, error) {
+	a := APIServer{
+		tokenExpiry:       TokenExpiryDefault,
+		accessTokenExpiry: AccessTokenExpiryDefault,
+		accessTokens:      make(map[string]time.Time),
+		refreshTokens:     make(map[string]*refreshFamily),
+		authorizations:    make(map[string]*authorization),
+		failures:          make(map[Endpoint][]Failure),
+	}
+
+	if nats != nil {
+		a.issuer = nats.SigningKeys
+		a.issuerAccount = nats.AccountPublicKey()
+	} else {
+		var err error
+
+		a.issuer, err = nkeys.CreateAccount()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/token", a.handleOAuth)
+	mux.HandleFunc("/oauth/device/code", a.handleDeviceCode)
+	mux.HandleFunc("/authorize", a.handleAuthorize)
+	mux.HandleFunc("/oauth/revoke", a.handleRevoke)
+	mux.HandleFunc("/api/core/tokens", a.handleCreateToken)
+	mux.HandleFunc("/api/admin/accounts/", a.handleAdminCreateToken)
+
+	a.server = httptest.NewServer(mux)
+	a.URL = a.server.URL
+	a.OAuthURL = a.server.URL + "/oauth/token"
+	a.ExchangeURL = a.server.URL + "/api"
+	a.DeviceAuthURL = a.server.URL + "/oauth/device/code"
+	a.AuthURL = a.server.URL + "/authorize"
+	a.RevocationURL = a.server.URL + "/oauth/revoke"
+
+	return &a, nil
+}
+
+// StartAPI Starts a fake API for the duration of a test, failing the test if
+// it can't be started. `nats` may be nil
+func StartAPI(t testing.TB, nats *Server) *APIServer {
+	t.Helper()
+
+	a, err := NewAPIServer(nats)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(a.Close)
+
+	return a
+}
+
+// Close Shuts down the server
+func (a *APIServer) Close() {
+	a.server.Close()
+}
+
+// IssuerPublicKey Returns the public key that NATS JWTs are signed with
+func (a *APIServer) IssuerPublicKey() string {
+	pubKey, _ := a.issuer.PublicKey()
+
+	return pubKey
+}
+
+// NewTokenClient Creates a token client that uses this server with valid
+// client credentials. If `account` is set, tokens are requested for it using
+// `AdminCreateToken`. The credentials are always sent using basic auth, so
+// that each scripted OAuth failure is used by exactly one request
+func (a *APIServer) NewTokenClient(account string) *connect.OAuthTokenClient {
+	conf := &clientcredentials.Config{
+		ClientID:       ClientID,
+		ClientSecret:   ClientSecret,
+		TokenURL:       a.OAuthURL,
+		EndpointParams: url.Values{"audience": []string{connect.DefaultAudience}},
+		AuthStyle:      oauth2.AuthStyleInHeader,
+	}
+
+	return connect.NewOAuthTokenClientWithTokenSource(a.ExchangeURL, account, conf.TokenSource(context.Background()))
+}
+
+// Fail Makes the next requests to `endpoint` fail with the supplied status
+// codes, in order. Use 401, 403 and 500 to simulate invalid credentials,
+// missing permissions and outages
+func (a *APIServer) Fail(endpoint Endpoint, statusCodes ...int) {
+	failures := make([]Failure, len(statusCodes))
+
+	for i, code := range statusCodes {
+		failures[i] = Failure{StatusCode: code}
+	}
+
+	a.Script(endpoint, failures...)
+}
+
+// Script Sets the responses for the next requests to `endpoint`, replacing
+// any that haven't been used yet
+func (a *APIServer) Script(endpoint Endpoint, failures ...Failure) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.failures[endpoint] = failures
+}
+
+// SetLatency Delays every response by `latency`, in addition to any scripted
+// latency
+func (a *APIServer) SetLatency(latency time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.latency = latency
+}
+
+// SetTokenExpiry Sets how long NATS JWTs are valid for. Set this to something
+// short to test refreshing
+func (a *APIServer) SetTokenExpiry(expiry time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.tokenExpiry = expiry
+}
+
+// SetAccessTokenExpiry Sets how long OAuth access tokens are valid for
+func (a *APIServer) SetAccessTokenExpiry(expiry time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.accessTokenExpiry = expiry
+}
+
+// Requests Returns the requests that have been made to `endpoint`
+func (a *APIServer) Requests(endpoint Endpoint) []Request {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	requests := make([]Request, 0)
+
+	for _, r := range a.requests {
+		if r.Endpoint == endpoint {
+			requests = append(requests, r)
+		}
+	}
+
+	return requests
+}
+
+// next Waits for any latency and returns the scripted failure for the
+// request. Its status code is zero if it should be handled normally
+func (a *APIServer) next(r *http.Request, endpoint Endpoint) Failure {
+	a.mutex.Lock()
+
+	latency := a.latency
+	var failure Failure
+
+	if failures := a.failures[endpoint]; len(failures) > 0 {
+		failure = failures[0]
+		a.failures[endpoint] = failures[1:]
+	}
+
+	a.mutex.Unlock()
+
+	select {
+	case <-time.After(latency + failure.Latency):
+	case <-r.Context().Done():
+	}
+
+	return failure
+}
+
+// writeRetryAfter Sets the Retry-After header if the failure has one
+func writeRetryAfter(w http.ResponseWriter, failure Failure) {
+	if failure.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+	}
+}
+
+// record Records a request that has been responded to
+func (a *APIServer) record(request Request) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.requests = append(a.requests, request)
+}
+
+func (a *APIServer) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	a.handleToken(w, r, Request{Endpoint: EndpointCreateToken})
+}
+
+func (a *APIServer) handleAdminCreateToken(w http.ResponseWriter, r *http.Request) {
+	// The path is /api/admin/accounts/{account_name}/tokens
+	account := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/accounts/"), "/tokens")
+
+	if !strings.HasSuffix(r.URL.Path, "/tokens") || account == "" || strings.Contains(account, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	a.handleToken(w, r, Request{
+		Endpoint: EndpointAdminCreateToken,
+		Account:  account,
+	})
+}
+
+// handleToken Exchanges an access token for a NATS JWT
+func (a *APIServer) handleToken(w http.ResponseWriter, r *http.Request, request Request) {
+	defer func() { a.record(request) }()
+
+	writeError := func(status int, message string) {
+		request.StatusCode = status
+		writeJSON(w, status, map[string]string{
+			"code":    strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_")),
+			"message": message,
+		})
+	}
+
+	if failure := a.next(r, request.Endpoint); failure.StatusCode != 0 {
+		writeRetryAfter(w, failure)
+		writeError(failure.StatusCode, "scripted failure")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	authorization := r.Header.Get("Authorization")
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+	ok := accessToken != authorization
+
+	a.mutex.Lock()
+	expiry, issued := a.accessTokens[accessToken]
+	tokenExpiry := a.tokenExpiry
+	a.mutex.Unlock()
+
+	if !ok || !issued || time.Now().After(expiry) {
+		writeError(http.StatusUnauthorized, "invalid or expired access token")
+		return
+	}
+
+	var data overmind.TokenRequestData
+
+	err := json.NewDecoder(r.Body).Decode(&data)
+
+	if err != nil {
+		writeError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request.UserPubKey = data.UserPubKey
+	request.UserName = data.UserName
+
+	if !nkeys.IsValidPublicUserKey(data.UserPubKey) {
+		writeError(http.StatusBadRequest, "invalid user public key")
+		return
+	}
+
+	claims := jwt.NewUserClaims(data.UserPubKey)
+	claims.Name = data.UserName
+	claims.IssuerAccount = a.issuerAccount
+	claims.Expires = time.Now().Add(tokenExpiry).Unix()
+
+	token, err := claims.Encode(a.issuer)
+
+	if err != nil {
+		writeError(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	request.StatusCode = http.StatusOK
+
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Write([]byte(token))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
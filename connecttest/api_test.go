@@ -0,0 +1,228 @@
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/overmindtech/connect"
+)
+
+func TestAPIServer(t *testing.T) {
+	s := Start(t)
+	api := StartAPI(t, s)
+
+	client := api.NewTokenClient("")
+
+	token, err := client.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Issuer != api.IssuerPublicKey() || claims.IssuerAccount != s.AccountPublicKey() {
+		t.Errorf("expected JWT to be issued by the server's signing key, got %v/%v", claims.Issuer, claims.IssuerAccount)
+	}
+
+	if requests := api.Requests(EndpointOAuth); len(requests) != 1 || requests[0].StatusCode != http.StatusOK {
+		t.Errorf("expected 1 successful OAuth request, got %v", requests)
+	}
+
+	requests := api.Requests(EndpointCreateToken)
+
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 CreateToken request, got %v", len(requests))
+	}
+
+	if requests[0].UserPubKey != claims.Subject {
+		t.Errorf("expected request for %v, got %v", claims.Subject, requests[0].UserPubKey)
+	}
+
+	// The JWT should be accepted by the NATS server
+	o := connect.NATSOptions{
+		Servers:     []string{s.URL},
+		TokenClient: client,
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	t.Run("with an account", func(t *testing.T) {
+		_, err := api.NewTokenClient("test-org").GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		requests := api.Requests(EndpointAdminCreateToken)
+
+		if len(requests) != 1 || requests[0].Account != "test-org" {
+			t.Errorf("expected an AdminCreateToken request for test-org, got %v", requests)
+		}
+	})
+
+	t.Run("with bad credentials", func(t *testing.T) {
+		c := connect.NewOAuthTokenClient(api.OAuthURL, api.ExchangeURL, connect.ClientCredentialsConfig{
+			ClientID:     ClientID,
+			ClientSecret: "wrong",
+		})
+
+		_, err := c.GetJWT()
+
+		if !errors.Is(err, connect.ErrUnauthorized) {
+			t.Errorf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+}
+
+func TestAPIServerStandalone(t *testing.T) {
+	api := StartAPI(t, nil)
+
+	token, err := api.NewTokenClient("").GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Issuer != api.IssuerPublicKey() || claims.IssuerAccount != "" {
+		t.Errorf("expected JWT to be issued by the account key, got %v/%v", claims.Issuer, claims.IssuerAccount)
+	}
+}
+
+func TestAPIServerFailures(t *testing.T) {
+	api := StartAPI(t, nil)
+
+	newClient := func() *connect.OAuthTokenClient {
+		c := api.NewTokenClient("")
+		c.Retry = connect.RetryPolicy{
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		}
+
+		return c
+	}
+
+	t.Run("OAuth 401", func(t *testing.T) {
+		api.Fail(EndpointOAuth, http.StatusUnauthorized)
+
+		_, err := newClient().GetJWT()
+
+		if !errors.Is(err, connect.ErrUnauthorized) {
+			t.Errorf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("OAuth 403", func(t *testing.T) {
+		api.Fail(EndpointOAuth, http.StatusForbidden)
+
+		_, err := newClient().GetJWT()
+
+		if !errors.Is(err, connect.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+	})
+
+	t.Run("CreateToken 403", func(t *testing.T) {
+		api.Fail(EndpointCreateToken, http.StatusForbidden)
+
+		_, err := newClient().GetJWT()
+
+		if !errors.Is(err, connect.ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+
+		var tokenErr *connect.TokenError
+
+		if !errors.As(err, &tokenErr) || tokenErr.Step != connect.TokenStepAPI {
+			t.Errorf("expected the API step to fail, got %v", err)
+		}
+	})
+
+	t.Run("CreateToken 500 then success", func(t *testing.T) {
+		api.Fail(EndpointCreateToken, http.StatusInternalServerError, http.StatusInternalServerError)
+
+		before := len(api.Requests(EndpointCreateToken))
+
+		_, err := newClient().GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if requests := len(api.Requests(EndpointCreateToken)) - before; requests != 3 {
+			t.Errorf("expected 3 requests, got %v", requests)
+		}
+	})
+
+	t.Run("CreateToken 500", func(t *testing.T) {
+		api.Fail(EndpointCreateToken, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+		_, err := newClient().GetJWT()
+
+		if !errors.Is(err, connect.ErrServerUnavailable) {
+			t.Errorf("expected ErrServerUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		api.Script(EndpointCreateToken, Failure{Latency: time.Second})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := newClient().GetJWTContext(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("short expiry", func(t *testing.T) {
+		api.SetTokenExpiry(2 * time.Second)
+		defer api.SetTokenExpiry(TokenExpiryDefault)
+
+		c := newClient()
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The expiry margin is capped at half the token's lifetime
+		time.Sleep(1100 * time.Millisecond)
+
+		before := len(api.Requests(EndpointCreateToken))
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Tokens that are about to expire are replaced
+		if requests := len(api.Requests(EndpointCreateToken)) - before; requests != 1 {
+			t.Errorf("expected the token to be replaced, got %v requests", requests)
+		}
+	})
+}
@@ -0,0 +1,364 @@
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+// ErrMockFailure The default error returned by scripted failures
+var ErrMockFailure = errors.New("connecttest: scripted failure")
+
+// Call A call made to a `MockTokenClient`
+type Call struct {
+	// The name of the method e.g. "GetJWT"
+	Method string
+	// The JWT that was returned by GetJWT
+	JWT string
+	// The error that was returned, if any
+	Err error
+	// The context that GetJWT or Sign was called with. This is
+	// `context.Background()` unless the context method was used
+	Context context.Context
+}
+
+// MockTokenClient A `connect.TokenClient` for unit tests. It records every
+// call, and can be scripted to fail, rotate its JWT or return an expired JWT.
+// It also implements `connect.ContextTokenClient`, `connect.Invalidator` and
+// `io.Closer`, so that the way `NATSOptions` uses them can be checked
+type MockTokenClient struct {
+	config UserConfig
+	keys   nkeys.KeyPair
+	issue  func(pubKey string, config UserConfig) (string, error)
+
+	mutex       sync.Mutex
+	issued      int
+	jwt         string
+	jwtErrors   []error
+	signErrors  []error
+	calls       []Call
+	invalidated bool
+}
+
+// NewMockTokenClient Creates a mock whose JWTs are signed by a newly
+// generated account key. These can't be used to connect to a NATS server; use
+// `Server.NewMockTokenClient` for that
+func NewMockTokenClient(config UserConfig) (*MockTokenClient, error) {
+	accountKeys, err := nkeys.CreateAccount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newMockTokenClient(config, func(pubKey string, config UserConfig) (string, error) {
+		return config.userClaims(pubKey).Encode(accountKeys)
+	})
+}
+
+// NewMockTokenClient Creates a mock whose JWTs are issued by this server, so
+// that it can be used to connect
+func (s *Server) NewMockTokenClient(config UserConfig) (*MockTokenClient, error) {
+	return newMockTokenClient(config, s.UserJWT)
+}
+
+func newMockTokenClient(config UserConfig, issue func(pubKey string, config UserConfig) (string, error)) (*MockTokenClient, error) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MockTokenClient{
+		config: config,
+		keys:   keys,
+		issue:  issue,
+	}, nil
+}
+
+// GetJWT Returns the current JWT, issuing one if there isn't one yet or if it
+// has been rotated or invalidated. Returns the next scripted error if there is
+// one
+func (m *MockTokenClient) GetJWT() (string, error) {
+	return m.GetJWTContext(context.Background())
+}
+
+// GetJWTContext Like `GetJWT`, but records `ctx` and fails if it is done
+func (m *MockTokenClient) GetJWTContext(ctx context.Context) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	call := Call{Method: "GetJWT", Context: ctx}
+
+	if ctx.Err() != nil {
+		call.Err = ctx.Err()
+	} else if len(m.jwtErrors) > 0 {
+		call.Err = m.jwtErrors[0]
+		m.jwtErrors = m.jwtErrors[1:]
+	} else if m.jwt == "" {
+		m.jwt, call.Err = m.issueJWT(m.config)
+	}
+
+	if call.Err == nil {
+		call.JWT = m.jwt
+	}
+
+	m.calls = append(m.calls, call)
+
+	return call.JWT, call.Err
+}
+
+// Sign Signs using the user's key. Returns the next scripted error if there
+// is one
+func (m *MockTokenClient) Sign(in []byte) ([]byte, error) {
+	return m.SignContext(context.Background(), in)
+}
+
+// SignContext Like `Sign`, but records `ctx` and fails if it is done
+func (m *MockTokenClient) SignContext(ctx context.Context, in []byte) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	call := Call{Method: "Sign", Context: ctx}
+
+	var signed []byte
+
+	if ctx.Err() != nil {
+		call.Err = ctx.Err()
+	} else if len(m.signErrors) > 0 {
+		call.Err = m.signErrors[0]
+		m.signErrors = m.signErrors[1:]
+	} else {
+		signed, call.Err = m.keys.Sign(in)
+	}
+
+	m.calls = append(m.calls, call)
+
+	return signed, call.Err
+}
+
+// InvalidateJWT Discards the current JWT so that a new one is issued next
+func (m *MockTokenClient) InvalidateJWT() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls = append(m.calls, Call{Method: "InvalidateJWT"})
+	m.jwt = ""
+	m.invalidated = true
+}
+
+// Close Records that the client has been closed. The mock keeps working
+// afterwards, so that assertions can still be made
+func (m *MockTokenClient) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls = append(m.calls, Call{Method: "Close"})
+
+	return nil
+}
+
+// FailGetJWT Makes the next calls to GetJWT return the supplied errors, in
+// order. With no arguments, the next call returns `ErrMockFailure`
+func (m *MockTokenClient) FailGetJWT(errs ...error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(errs) == 0 {
+		errs = []error{ErrMockFailure}
+	}
+
+	m.jwtErrors = append(m.jwtErrors, errs...)
+}
+
+// FailSign Makes the next calls to Sign return the supplied errors, in order.
+// With no arguments, the next call returns `ErrMockFailure`
+func (m *MockTokenClient) FailSign(errs ...error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(errs) == 0 {
+		errs = []error{ErrMockFailure}
+	}
+
+	m.signErrors = append(m.signErrors, errs...)
+}
+
+// Rotate Makes the next call to GetJWT return a newly issued JWT
+func (m *MockTokenClient) Rotate() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.jwt = ""
+}
+
+// Expire Replaces the current JWT with one that has already expired, which
+// GetJWT will keep returning until the mock is rotated or invalidated
+func (m *MockTokenClient) Expire() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	config := m.config
+	config.Expiry = -time.Minute
+
+	token, err := m.issueJWT(config)
+
+	if err != nil {
+		return err
+	}
+
+	m.jwt = token
+
+	return nil
+}
+
+// issueJWT Issues a JWT for the user's key. Each JWT is tagged with a serial
+// number, so that rotating always gives a different JWT
+func (m *MockTokenClient) issueJWT(config UserConfig) (string, error) {
+	pubKey, err := m.keys.PublicKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	m.issued++
+	config.Tags = append(append([]string{}, config.Tags...), fmt.Sprintf("connecttest-serial:%v", m.issued))
+
+	return m.issue(pubKey, config)
+}
+
+// PublicKey Returns the user's public key
+func (m *MockTokenClient) PublicKey() string {
+	pubKey, _ := m.keys.PublicKey()
+
+	return pubKey
+}
+
+// Calls Returns every call that has been made, in order
+func (m *MockTokenClient) Calls() []Call {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Call{}, m.calls...)
+}
+
+// CallCount Returns how many times `method` has been called
+func (m *MockTokenClient) CallCount(method string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var count int
+
+	for _, c := range m.calls {
+		if c.Method == method {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Contexts Returns the contexts that GetJWT and Sign were called with, in
+// order
+func (m *MockTokenClient) Contexts() []context.Context {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	contexts := make([]context.Context, 0)
+
+	for _, c := range m.calls {
+		if c.Context != nil {
+			contexts = append(contexts, c.Context)
+		}
+	}
+
+	return contexts
+}
+
+// Issued Returns how many JWTs have been issued, including expired ones
+func (m *MockTokenClient) Issued() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.issued
+}
+
+// JWTs Returns the distinct JWTs that GetJWT has returned, in order
+func (m *MockTokenClient) JWTs() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jwts := make([]string, 0)
+
+	for _, c := range m.calls {
+		if c.JWT != "" && (len(jwts) == 0 || jwts[len(jwts)-1] != c.JWT) {
+			jwts = append(jwts, c.JWT)
+		}
+	}
+
+	return jwts
+}
+
+// Reset Forgets all calls and scripted errors. The current JWT is kept
+func (m *MockTokenClient) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.calls = nil
+	m.jwtErrors = nil
+	m.signErrors = nil
+	m.invalidated = false
+}
+
+// AssertGetJWTCalls Fails the test unless GetJWT has been called `n` times
+func (m *MockTokenClient) AssertGetJWTCalls(t testing.TB, n int) {
+	t.Helper()
+
+	if count := m.CallCount("GetJWT"); count != n {
+		t.Errorf("expected GetJWT to be called %v times, got %v", n, count)
+	}
+}
+
+// AssertSignCalls Fails the test unless Sign has been called `n` times
+func (m *MockTokenClient) AssertSignCalls(t testing.TB, n int) {
+	t.Helper()
+
+	if count := m.CallCount("Sign"); count != n {
+		t.Errorf("expected Sign to be called %v times, got %v", n, count)
+	}
+}
+
+// AssertInvalidated Fails the test unless InvalidateJWT has been called
+func (m *MockTokenClient) AssertInvalidated(t testing.TB) {
+	t.Helper()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.invalidated {
+		t.Error("expected the JWT to be invalidated")
+	}
+}
+
+// AssertClosed Fails the test unless Close has been called
+func (m *MockTokenClient) AssertClosed(t testing.TB) {
+	t.Helper()
+
+	if m.CallCount("Close") == 0 {
+		t.Error("expected the token client to be closed")
+	}
+}
+
+// AssertRotations Fails the test unless GetJWT has returned `n` different
+// JWTs over time
+func (m *MockTokenClient) AssertRotations(t testing.TB, n int) {
+	t.Helper()
+
+	if count := len(m.JWTs()); count != n {
+		t.Errorf("expected %v different JWTs, got %v", n, count)
+	}
+}
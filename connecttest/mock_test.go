@@ -0,0 +1,214 @@
+package connecttest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+)
+
+func TestMockTokenClient(t *testing.T) {
+	m, err := NewMockTokenClient(UserConfig{Name: "mock"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := m.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, _ := m.GetJWT()
+
+	if again != first {
+		t.Error("expected the JWT to be reused")
+	}
+
+	m.Rotate()
+
+	rotated, _ := m.GetJWT()
+
+	if rotated == first {
+		t.Error("expected a new JWT after rotating")
+	}
+
+	m.AssertGetJWTCalls(t, 3)
+	m.AssertRotations(t, 2)
+
+	t.Run("failing GetJWT", func(t *testing.T) {
+		expected := errors.New("boom")
+
+		m.FailGetJWT(expected)
+
+		if _, err := m.GetJWT(); !errors.Is(err, expected) {
+			t.Errorf("expected scripted error, got %v", err)
+		}
+
+		if _, err := m.GetJWT(); err != nil {
+			t.Errorf("expected only one failure, got %v", err)
+		}
+	})
+
+	t.Run("failing Sign", func(t *testing.T) {
+		m.Reset()
+		m.FailSign()
+
+		if _, err := m.Sign([]byte{1}); !errors.Is(err, ErrMockFailure) {
+			t.Errorf("expected ErrMockFailure, got %v", err)
+		}
+
+		data := []byte{1, 156, 230, 4}
+
+		signed, err := m.Sign(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		verifier, _ := nkeys.FromPublicKey(m.PublicKey())
+
+		if err = verifier.Verify(data, signed); err != nil {
+			t.Error(err)
+		}
+
+		m.AssertSignCalls(t, 2)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		err := m.Expire()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, _ := m.GetJWT()
+
+		claims, err := jwt.DecodeUserClaims(token)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if claims.Expires == 0 || time.Unix(claims.Expires, 0).After(time.Now()) {
+			t.Errorf("expected an expired JWT, got expiry %v", claims.Expires)
+		}
+
+		m.InvalidateJWT()
+		m.AssertInvalidated(t)
+
+		token, _ = m.GetJWT()
+
+		claims, _ = jwt.DecodeUserClaims(token)
+
+		if claims.Expires != 0 {
+			t.Error("expected a new JWT after invalidating")
+		}
+	})
+
+	t.Run("contexts", func(t *testing.T) {
+		m.Reset()
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		_, err := m.GetJWTContext(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cancel()
+
+		if _, err = m.SignContext(ctx, []byte{1}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		if contexts := m.Contexts(); len(contexts) != 2 || contexts[0] != ctx || contexts[1] != ctx {
+			t.Errorf("expected both calls to record the context, got %v", contexts)
+		}
+	})
+}
+
+func TestMockTokenClientPermissions(t *testing.T) {
+	m, err := NewMockTokenClient(UserConfig{
+		Name:          "mock",
+		Publish:       []string{"request.>"},
+		DenyPublish:   []string{"request.scope.secret.>"},
+		Subscribe:     []string{"_INBOX.>"},
+		DenySubscribe: []string{"_INBOX.secret"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = connect.CheckPermissions(m, []string{"request.all"}, []string{"_INBOX.abc"})
+
+	if err != nil {
+		t.Errorf("expected the allowed subjects to be permitted, got %v", err)
+	}
+
+	err = connect.CheckPermissions(m, []string{"request.scope.secret.x", "cancel.all"}, []string{"_INBOX.secret"})
+
+	var permErr connect.PermissionsError
+
+	if !errors.As(err, &permErr) {
+		t.Fatalf("expected a PermissionsError, got %v", err)
+	}
+
+	if len(permErr.Publish) != 2 || len(permErr.Subscribe) != 1 {
+		t.Errorf("expected the JWT's permissions to be applied, got %v", permErr)
+	}
+}
+
+func TestMockTokenClientNATS(t *testing.T) {
+	s := Start(t)
+
+	m, err := s.NewMockTokenClient(UserConfig{Name: "mock"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first attempt fails, after which we should retry
+	m.FailGetJWT()
+
+	o := connect.NATSOptions{
+		Servers:          []string{s.URL},
+		TokenClient:      m,
+		NumRetries:       2,
+		RetryDelay:       10 * time.Millisecond,
+		CloseTokenClient: true,
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := m.Calls()
+
+	if len(calls) < 2 || calls[0].Err == nil || calls[1].Err != nil {
+		t.Errorf("expected a failed GetJWT followed by a successful one, got %v", calls)
+	}
+
+	m.AssertRotations(t, 1)
+	m.AssertSignCalls(t, 1)
+
+	conn.Close()
+
+	// The closed handler runs asynchronously
+	deadline := time.Now().Add(time.Second)
+
+	for m.CallCount("Close") == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	m.AssertClosed(t)
+}
@@ -0,0 +1,478 @@
+package connecttest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// The grant types that the fake token endpoint supports
+const (
+	grantClientCredentials = "client_credentials"
+	grantRefreshToken      = "refresh_token"
+	grantAuthorizationCode = "authorization_code"
+	grantDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	grantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+)
+
+// refreshFamily The refresh tokens that descend from a single login. Only the
+// most recent one can be redeemed, and redeeming an older one revokes them all
+type refreshFamily struct {
+	current string
+	revoked bool
+}
+
+// authorization An authorization code or device code that hasn't been
+// redeemed yet
+type authorization struct {
+	grantType string
+	scope     string
+	// The PKCE code challenge, for authorization codes
+	challenge string
+	// How many more times polling for a device code will be told to wait
+	pendingPolls int
+}
+
+// tokenError An error response from the token endpoint
+type tokenError struct {
+	status int
+	code   string
+}
+
+// SetPendingPolls Sets how many times the token endpoint answers
+// `authorization_pending` for each device code before the user is treated as
+// having logged in
+func (a *APIServer) SetPendingPolls(polls int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pendingPolls = polls
+}
+
+// IssueRefreshToken Returns a new refresh token, as if a user had logged in.
+// It is rotated every time it is redeemed, and reusing an old one revokes it
+func (a *APIServer) IssueRefreshToken() (string, error) {
+	token, err := randomToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.refreshTokens[token] = &refreshFamily{current: token}
+
+	return token, nil
+}
+
+// AcceptAccessToken Makes the API accept an access token that wasn't issued by
+// the fake OAuth server, e.g. one from an API key exchange
+func (a *APIServer) AcceptAccessToken(token string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.accessTokens[token] = time.Now().Add(a.accessTokenExpiry)
+}
+
+// oauthErrorCode Returns the OAuth error code for a scripted status code
+func oauthErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_grant"
+	case http.StatusUnauthorized:
+		return "invalid_client"
+	case http.StatusForbidden:
+		return "access_denied"
+	default:
+		return "server_error"
+	}
+}
+
+// clientID Returns the client that made the request, which must be
+// `ClientID`. Public clients don't send a secret, but if one is sent it must
+// be `ClientSecret`
+func clientID(r *http.Request) (string, bool) {
+	// Credentials can be sent using basic auth or in the form
+	id, secret, ok := r.BasicAuth()
+
+	if !ok {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	return id, id == ClientID && (secret == "" || secret == ClientSecret)
+}
+
+func (a *APIServer) handleOAuth(w http.ResponseWriter, r *http.Request) {
+	request := Request{Endpoint: EndpointOAuth}
+	defer func() { a.record(request) }()
+
+	writeError := func(status int, code string) {
+		request.StatusCode = status
+		writeJSON(w, status, map[string]string{
+			"error":             code,
+			"error_description": http.StatusText(status),
+		})
+	}
+
+	if failure := a.next(r, EndpointOAuth); failure.StatusCode != 0 {
+		writeRetryAfter(w, failure)
+		writeError(failure.StatusCode, oauthErrorCode(failure.StatusCode))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	err := r.ParseForm()
+
+	if err != nil {
+		writeError(http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	request.Form = r.PostForm
+
+	var response map[string]interface{}
+	var tokenErr *tokenError
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case grantClientCredentials:
+		response, tokenErr = a.clientCredentialsGrant(r)
+	case grantRefreshToken:
+		response, tokenErr = a.refreshTokenGrant(r)
+	case grantAuthorizationCode, grantDeviceCode:
+		response, tokenErr = a.authorizationGrant(r, grantType)
+	case grantTokenExchange:
+		response, tokenErr = a.tokenExchangeGrant(r)
+	default:
+		tokenErr = &tokenError{http.StatusBadRequest, "unsupported_grant_type"}
+	}
+
+	if tokenErr != nil {
+		writeError(tokenErr.status, tokenErr.code)
+		return
+	}
+
+	request.StatusCode = http.StatusOK
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// clientCredentialsGrant Issues an access token to a confidential client
+func (a *APIServer) clientCredentialsGrant(r *http.Request) (map[string]interface{}, *tokenError) {
+	id, secret, ok := r.BasicAuth()
+
+	if !ok {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if id != ClientID || secret != ClientSecret {
+		return nil, &tokenError{http.StatusUnauthorized, "invalid_client"}
+	}
+
+	return a.tokenResponse(false)
+}
+
+// refreshTokenGrant Redeems a refresh token, rotating it
+func (a *APIServer) refreshTokenGrant(r *http.Request) (map[string]interface{}, *tokenError) {
+	if _, ok := clientID(r); !ok {
+		return nil, &tokenError{http.StatusUnauthorized, "invalid_client"}
+	}
+
+	presented := r.PostForm.Get("refresh_token")
+
+	rotated, err := randomToken()
+
+	if err != nil {
+		return nil, &tokenError{http.StatusInternalServerError, "server_error"}
+	}
+
+	a.mutex.Lock()
+
+	family := a.refreshTokens[presented]
+
+	switch {
+	case family == nil || family.revoked:
+		a.mutex.Unlock()
+		return nil, &tokenError{http.StatusForbidden, "invalid_grant"}
+	case presented != family.current:
+		// Reuse detected, revoke the whole family
+		family.revoked = true
+		a.mutex.Unlock()
+
+		return nil, &tokenError{http.StatusForbidden, "invalid_grant"}
+	}
+
+	family.current = rotated
+	a.refreshTokens[rotated] = family
+	a.mutex.Unlock()
+
+	response, tokenErr := a.tokenResponse(false)
+
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	response["refresh_token"] = rotated
+
+	return response, nil
+}
+
+// authorizationGrant Redeems an authorization code or device code. A refresh
+// token is issued too if the `offline_access` scope was requested
+func (a *APIServer) authorizationGrant(r *http.Request, grantType string) (map[string]interface{}, *tokenError) {
+	if _, ok := clientID(r); !ok {
+		return nil, &tokenError{http.StatusUnauthorized, "invalid_client"}
+	}
+
+	code := r.PostForm.Get("code")
+
+	if grantType == grantDeviceCode {
+		code = r.PostForm.Get("device_code")
+	}
+
+	a.mutex.Lock()
+
+	auth := a.authorizations[code]
+
+	if auth == nil || auth.grantType != grantType {
+		a.mutex.Unlock()
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant"}
+	}
+
+	if auth.pendingPolls > 0 {
+		auth.pendingPolls--
+		a.mutex.Unlock()
+
+		return nil, &tokenError{http.StatusBadRequest, "authorization_pending"}
+	}
+
+	delete(a.authorizations, code)
+	a.mutex.Unlock()
+
+	if grantType == grantAuthorizationCode && oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != auth.challenge {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_grant"}
+	}
+
+	return a.tokenResponse(strings.Contains(" "+auth.scope+" ", " offline_access "))
+}
+
+// tokenExchangeGrant Exchanges any subject token for an access token (RFC
+// 8693). Use `Fail()` with 400 to simulate an untrusted subject token
+func (a *APIServer) tokenExchangeGrant(r *http.Request) (map[string]interface{}, *tokenError) {
+	if r.PostForm.Get("subject_token") == "" || r.PostForm.Get("subject_token_type") == "" {
+		return nil, &tokenError{http.StatusBadRequest, "invalid_request"}
+	}
+
+	response, tokenErr := a.tokenResponse(false)
+
+	if tokenErr != nil {
+		return nil, tokenErr
+	}
+
+	response["issued_token_type"] = "urn:ietf:params:oauth:token-type:access_token"
+
+	return response, nil
+}
+
+// tokenResponse Issues a new access token, and optionally a refresh token
+func (a *APIServer) tokenResponse(refresh bool) (map[string]interface{}, *tokenError) {
+	accessToken, err := randomToken()
+
+	if err != nil {
+		return nil, &tokenError{http.StatusInternalServerError, "server_error"}
+	}
+
+	a.mutex.Lock()
+	expiry := a.accessTokenExpiry
+	a.accessTokens[accessToken] = time.Now().Add(expiry)
+	a.mutex.Unlock()
+
+	response := map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(expiry.Seconds()),
+	}
+
+	if refresh {
+		refreshToken, err := a.IssueRefreshToken()
+
+		if err != nil {
+			return nil, &tokenError{http.StatusInternalServerError, "server_error"}
+		}
+
+		response["refresh_token"] = refreshToken
+	}
+
+	return response, nil
+}
+
+// handleDeviceCode Starts a device authorization. The user is treated as
+// having logged in once the token endpoint has been polled enough times, see
+// `SetPendingPolls()`
+func (a *APIServer) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	request := Request{Endpoint: EndpointDeviceCode}
+	defer func() { a.record(request) }()
+
+	writeError := func(status int, code string) {
+		request.StatusCode = status
+		writeJSON(w, status, map[string]string{"error": code})
+	}
+
+	if failure := a.next(r, EndpointDeviceCode); failure.StatusCode != 0 {
+		writeRetryAfter(w, failure)
+		writeError(failure.StatusCode, oauthErrorCode(failure.StatusCode))
+		return
+	}
+
+	err := r.ParseForm()
+
+	if err != nil || r.Method != http.MethodPost {
+		writeError(http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	request.Form = r.PostForm
+
+	if _, ok := clientID(r); !ok {
+		writeError(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	deviceCode, err := randomToken()
+
+	if err != nil {
+		writeError(http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	userCode := strings.ToUpper(deviceCode[:4] + "-" + deviceCode[4:8])
+
+	a.mutex.Lock()
+	a.authorizations[deviceCode] = &authorization{
+		grantType:    grantDeviceCode,
+		scope:        r.PostForm.Get("scope"),
+		pendingPolls: a.pendingPolls,
+	}
+	a.mutex.Unlock()
+
+	request.StatusCode = http.StatusOK
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":      deviceCode,
+		"user_code":        userCode,
+		"verification_uri": a.URL + "/activate",
+		"expires_in":       300,
+		"interval":         1,
+	})
+}
+
+// handleAuthorize Logs the user in straight away and redirects back to the
+// client with an authorization code. PKCE is required
+func (a *APIServer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	request := Request{
+		Endpoint: EndpointAuthorize,
+		Form:     query,
+	}
+	defer func() { a.record(request) }()
+
+	writeError := func(status int, message string) {
+		request.StatusCode = status
+		http.Error(w, message, status)
+	}
+
+	if failure := a.next(r, EndpointAuthorize); failure.StatusCode != 0 {
+		writeError(failure.StatusCode, "scripted failure")
+		return
+	}
+
+	if query.Get("client_id") != ClientID || query.Get("response_type") != "code" {
+		writeError(http.StatusBadRequest, "unknown client or response type")
+		return
+	}
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		writeError(http.StatusBadRequest, "PKCE is required")
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+
+	if err != nil || redirect.Host == "" {
+		writeError(http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	code, err := randomToken()
+
+	if err != nil {
+		writeError(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.mutex.Lock()
+	a.authorizations[code] = &authorization{
+		grantType: grantAuthorizationCode,
+		scope:     query.Get("scope"),
+		challenge: query.Get("code_challenge"),
+	}
+	a.mutex.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	request.StatusCode = http.StatusFound
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleRevoke Revokes an access token or a refresh token (RFC 7009).
+// Revoking a refresh token revokes every token in its family
+func (a *APIServer) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	request := Request{Endpoint: EndpointRevoke}
+	defer func() { a.record(request) }()
+
+	if failure := a.next(r, EndpointRevoke); failure.StatusCode != 0 {
+		request.StatusCode = failure.StatusCode
+		writeRetryAfter(w, failure)
+		writeJSON(w, failure.StatusCode, map[string]string{"error": oauthErrorCode(failure.StatusCode)})
+
+		return
+	}
+
+	err := r.ParseForm()
+
+	if err != nil || r.Method != http.MethodPost {
+		request.StatusCode = http.StatusBadRequest
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	request.Form = r.PostForm
+	token := r.PostForm.Get("token")
+
+	a.mutex.Lock()
+
+	if family := a.refreshTokens[token]; family != nil {
+		family.revoked = true
+	}
+
+	delete(a.accessTokens, token)
+	a.mutex.Unlock()
+
+	request.StatusCode = http.StatusOK
+
+	w.WriteHeader(http.StatusOK)
+}
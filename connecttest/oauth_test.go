@@ -0,0 +1,146 @@
+package connecttest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// postForm Posts a form to the fake OAuth server and decodes the JSON response
+func postForm(t *testing.T, endpoint string, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	res, err := http.PostForm(endpoint, form)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer res.Body.Close()
+
+	body := make(map[string]interface{})
+
+	if res.Header.Get("Content-Type") == "application/json" {
+		err = json.NewDecoder(res.Body).Decode(&body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode, body
+}
+
+func TestAPIServerRefreshTokens(t *testing.T) {
+	api := StartAPI(t, nil)
+
+	refreshToken, err := api.IssueRefreshToken()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redeem := func(token string) (int, map[string]interface{}) {
+		return postForm(t, api.OAuthURL, url.Values{
+			"grant_type":    {grantRefreshToken},
+			"client_id":     {ClientID},
+			"refresh_token": {token},
+		})
+	}
+
+	status, body := redeem(refreshToken)
+
+	if status != http.StatusOK || body["refresh_token"] == refreshToken {
+		t.Fatalf("expected the refresh token to be rotated, got %v %v", status, body)
+	}
+
+	rotated, _ := body["refresh_token"].(string)
+
+	// Reusing the original revokes the rotated one too
+	if status, body = redeem(refreshToken); status != http.StatusForbidden || body["error"] != "invalid_grant" {
+		t.Errorf("expected reuse to be rejected, got %v %v", status, body)
+	}
+
+	if status, _ = redeem(rotated); status != http.StatusForbidden {
+		t.Errorf("expected the family to be revoked, got %v", status)
+	}
+
+	t.Run("revoking", func(t *testing.T) {
+		refreshToken, err := api.IssueRefreshToken()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, _ := postForm(t, api.RevocationURL, url.Values{"token": {refreshToken}})
+
+		if status != http.StatusOK {
+			t.Fatalf("expected revocation to succeed, got %v", status)
+		}
+
+		if status, _ = redeem(refreshToken); status != http.StatusForbidden {
+			t.Errorf("expected a revoked token to be rejected, got %v", status)
+		}
+
+		if requests := api.Requests(EndpointRevoke); len(requests) != 1 || requests[0].Form.Get("token") != refreshToken {
+			t.Errorf("expected the revocation to be recorded, got %v", requests)
+		}
+	})
+}
+
+func TestAPIServerDeviceCode(t *testing.T) {
+	api := StartAPI(t, nil)
+	api.SetPendingPolls(1)
+
+	status, body := postForm(t, api.DeviceAuthURL, url.Values{
+		"client_id": {ClientID},
+		"scope":     {"offline_access"},
+	})
+
+	if status != http.StatusOK {
+		t.Fatalf("expected a device code, got %v %v", status, body)
+	}
+
+	poll := url.Values{
+		"grant_type":  {grantDeviceCode},
+		"client_id":   {ClientID},
+		"device_code": {body["device_code"].(string)},
+	}
+
+	if status, body = postForm(t, api.OAuthURL, poll); status != http.StatusBadRequest || body["error"] != "authorization_pending" {
+		t.Errorf("expected the first poll to be pending, got %v %v", status, body)
+	}
+
+	if status, body = postForm(t, api.OAuthURL, poll); status != http.StatusOK || body["refresh_token"] == nil {
+		t.Errorf("expected tokens including a refresh token, got %v %v", status, body)
+	}
+
+	// Device codes can only be redeemed once
+	if status, _ = postForm(t, api.OAuthURL, poll); status != http.StatusBadRequest {
+		t.Errorf("expected the device code to be used up, got %v", status)
+	}
+}
+
+func TestAPIServerOAuthFailures(t *testing.T) {
+	api := StartAPI(t, nil)
+
+	api.Script(EndpointOAuth, Failure{StatusCode: http.StatusServiceUnavailable, RetryAfter: 2 * time.Second})
+
+	res, err := http.PostForm(api.OAuthURL, url.Values{"grant_type": {grantClientCredentials}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") != "2" {
+		t.Errorf("expected a scripted 503 with Retry-After, got %v %v", res.StatusCode, res.Header)
+	}
+
+	if status, body := postForm(t, api.OAuthURL, url.Values{"grant_type": {"password"}}); status != http.StatusBadRequest || body["error"] != "unsupported_grant_type" {
+		t.Errorf("expected unsupported_grant_type, got %v %v", status, body)
+	}
+}
@@ -0,0 +1,364 @@
+package connecttest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxyChunkSize The most data that is forwarded at once
+const proxyChunkSize = 4096
+
+// Proxy A TCP proxy that sits between clients and a server and injects faults:
+// latency, bandwidth limits, half-open connections, resets and partitions. It
+// is used to test how connections behave when the network misbehaves
+type Proxy struct {
+	// The URL that clients should connect to
+	URL string
+
+	target   string
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mutex       sync.Mutex
+	latency     time.Duration
+	bandwidth   int
+	partitioned bool
+	flowing     chan struct{}
+	conns       map[*proxyConn]struct{}
+	closed      bool
+}
+
+// NewProxy Starts a proxy on a random port on localhost that forwards to
+// `target`, which can be a `host:port` or a URL such as `Server.URL`. The proxy
+// must be closed using `Close()`
+func NewProxy(target string) (*Proxy, error) {
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		target = u.Host
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	flowing := make(chan struct{})
+	close(flowing)
+
+	p := Proxy{
+		URL:      "nats://" + listener.Addr().String(),
+		target:   target,
+		listener: listener,
+		flowing:  flowing,
+		conns:    make(map[*proxyConn]struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.accept()
+
+	return &p, nil
+}
+
+// StartProxy Starts a proxy for the duration of a test, failing the test if it
+// can't be started
+func StartProxy(t testing.TB, target string) *Proxy {
+	t.Helper()
+
+	p, err := NewProxy(target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// Close Stops accepting connections and resets all current ones
+func (p *Proxy) Close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+
+	p.listener.Close()
+	p.Reset()
+	p.wg.Wait()
+}
+
+// SetLatency Delays data in each direction by `latency`, so the round trip
+// time increases by twice this
+func (p *Proxy) SetLatency(latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.latency = latency
+}
+
+// SetBandwidth Limits each direction of each connection to `bytesPerSecond`.
+// Zero removes the limit
+func (p *Proxy) SetBandwidth(bytesPerSecond int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.bandwidth = bytesPerSecond
+}
+
+// SetHalfOpen Stops forwarding data without closing any connections, as
+// happens when the other end disappears without the connection being closed.
+// New connections are accepted but also get no data. Data that was sent in the
+// meantime is delivered once this is turned off again
+func (p *Proxy) SetHalfOpen(halfOpen bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	select {
+	case <-p.flowing:
+		if halfOpen {
+			p.flowing = make(chan struct{})
+		}
+	default:
+		if !halfOpen {
+			close(p.flowing)
+		}
+	}
+}
+
+// Reset Abruptly closes all current connections, sending a TCP RST to both
+// the client and the server
+func (p *Proxy) Reset() {
+	p.mutex.Lock()
+	conns := make([]*proxyConn, 0, len(p.conns))
+
+	for c := range p.conns {
+		conns = append(conns, c)
+	}
+
+	p.mutex.Unlock()
+
+	for _, c := range conns {
+		c.reset()
+	}
+}
+
+// Partition Resets all current connections and refuses new ones until
+// `Heal()` is called
+func (p *Proxy) Partition() {
+	p.mutex.Lock()
+	p.partitioned = true
+	p.mutex.Unlock()
+
+	p.Reset()
+}
+
+// Heal Ends a partition and any half-open state
+func (p *Proxy) Heal() {
+	p.mutex.Lock()
+	p.partitioned = false
+	p.mutex.Unlock()
+
+	p.SetHalfOpen(false)
+}
+
+// Connections Returns the number of connections currently being proxied
+func (p *Proxy) Connections() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.conns)
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+
+	for {
+		client, err := p.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		refuse := p.partitioned || p.closed
+		p.mutex.Unlock()
+
+		if refuse {
+			resetConn(client)
+			continue
+		}
+
+		server, err := net.DialTimeout("tcp", p.target, 5*time.Second)
+
+		if err != nil {
+			resetConn(client)
+			continue
+		}
+
+		c := &proxyConn{
+			client: client,
+			server: server,
+			done:   make(chan struct{}),
+		}
+
+		p.mutex.Lock()
+
+		if p.closed {
+			p.mutex.Unlock()
+			c.reset()
+
+			continue
+		}
+
+		p.conns[c] = struct{}{}
+		p.mutex.Unlock()
+
+		p.wg.Add(1)
+
+		go func() {
+			defer p.wg.Done()
+
+			c.run(p)
+
+			p.mutex.Lock()
+			delete(p.conns, c)
+			p.mutex.Unlock()
+		}()
+	}
+}
+
+// state Returns the current fault settings
+func (p *Proxy) state() (time.Duration, int, chan struct{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.latency, p.bandwidth, p.flowing
+}
+
+// proxyConn A single proxied connection
+type proxyConn struct {
+	client net.Conn
+	server net.Conn
+
+	once sync.Once
+	done chan struct{}
+}
+
+// chunk Data waiting to be forwarded
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// run Forwards data in both directions until either side closes
+func (c *proxyConn) run(p *Proxy) {
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		c.pipe(p, c.client, c.server)
+	}()
+
+	go func() {
+		defer wg.Done()
+		c.pipe(p, c.server, c.client)
+	}()
+
+	wg.Wait()
+}
+
+// pipe Forwards data from `src` to `dst`, applying the proxy's faults. Reading
+// and writing happen separately so that latency doesn't limit throughput
+func (c *proxyConn) pipe(p *Proxy, src net.Conn, dst net.Conn) {
+	chunks := make(chan chunk, 1024)
+
+	go func() {
+		defer close(chunks)
+
+		for {
+			buf := make([]byte, proxyChunkSize)
+
+			n, err := src.Read(buf)
+
+			if n > 0 {
+				latency, _, _ := p.state()
+
+				select {
+				case chunks <- chunk{data: buf[:n], due: time.Now().Add(latency)}:
+				case <-c.done:
+					return
+				}
+			}
+
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					// Pass on the close once everything has been sent
+					return
+				}
+
+				c.close()
+
+				return
+			}
+		}
+	}()
+
+	for ch := range chunks {
+		time.Sleep(time.Until(ch.due))
+
+		_, bandwidth, flowing := p.state()
+
+		select {
+		case <-flowing:
+		case <-c.done:
+			return
+		}
+
+		if bandwidth > 0 {
+			time.Sleep(time.Duration(len(ch.data)) * time.Second / time.Duration(bandwidth))
+		}
+
+		_, err := dst.Write(ch.data)
+
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+
+	c.close()
+}
+
+// close Closes both sides of the connection normally
+func (c *proxyConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.client.Close()
+		c.server.Close()
+	})
+}
+
+// reset Closes both sides of the connection with a TCP RST
+func (c *proxyConn) reset() {
+	c.once.Do(func() {
+		close(c.done)
+		resetConn(c.client)
+		resetConn(c.server)
+	})
+}
+
+// resetConn Closes a connection so that the other end gets a TCP RST rather
+// than a FIN
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+
+	conn.Close()
+}
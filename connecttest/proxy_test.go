@@ -0,0 +1,402 @@
+package connecttest
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+)
+
+// testEvents Records the connection handlers that NATS calls, in order
+type testEvents struct {
+	mutex  sync.Mutex
+	events []string
+	times  map[string]time.Time
+	ch     chan string
+}
+
+// newTestProxyOptions Starts a server with a proxy in front of it and returns
+// options that connect through the proxy, recording connection events
+func newTestProxyOptions(t *testing.T) (*Server, *Proxy, connect.NATSOptions, *testEvents) {
+	t.Helper()
+
+	s := Start(t)
+	p := StartProxy(t, s.URL)
+
+	o, err := s.NATSOptions(UserConfig{Name: t.Name()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.Servers = []string{p.URL}
+
+	e := &testEvents{
+		times: make(map[string]time.Time),
+		ch:    make(chan string, 100),
+	}
+
+	o.DisconnectErrHandler = func(c *nats.Conn, err error) { e.record("disconnected") }
+	o.ReconnectHandler = func(c *nats.Conn) { e.record("reconnected") }
+	o.ClosedHandler = func(c *nats.Conn) { e.record("closed") }
+
+	return s, p, o, e
+}
+
+func (e *testEvents) record(event string) {
+	e.mutex.Lock()
+	e.events = append(e.events, event)
+
+	if _, ok := e.times[event]; !ok {
+		e.times[event] = time.Now()
+	}
+	e.mutex.Unlock()
+
+	e.ch <- event
+}
+
+// wait Waits for `event` to happen, failing the test if it doesn't
+func (e *testEvents) wait(t *testing.T, event string, timeout time.Duration) {
+	t.Helper()
+
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case got := <-e.ch:
+			if got == event {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for %v, got %v", event, e.Events())
+		}
+	}
+}
+
+// Events Returns the events so far, in order
+func (e *testEvents) Events() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]string{}, e.events...)
+}
+
+// Time Returns when `event` first happened
+func (e *testEvents) Time(event string) time.Time {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.times[event]
+}
+
+// assertEvents Fails the test unless exactly the expected events have happened
+func assertEvents(t *testing.T, e *testEvents, expected ...string) {
+	t.Helper()
+
+	events := e.Events()
+
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, events)
+		}
+	}
+}
+
+// assertRoundTrip Fails the test unless a message can be sent and received
+func assertRoundTrip(t *testing.T, nc *nats.Conn) {
+	t.Helper()
+
+	sub, err := nc.SubscribeSync(nats.NewInbox())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer sub.Unsubscribe()
+
+	err = nc.Publish(sub.Subject, []byte("ping"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = sub.NextMsg(5 * time.Second)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProxyLatency(t *testing.T) {
+	_, p, o, _ := newTestProxyOptions(t)
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	if p.Connections() != 1 {
+		t.Errorf("expected 1 proxied connection, got %v", p.Connections())
+	}
+
+	p.SetLatency(50 * time.Millisecond)
+
+	rtt, err := conn.Underlying().RTT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rtt < 100*time.Millisecond {
+		t.Errorf("expected RTT of at least 100ms, got %v", rtt)
+	}
+}
+
+func TestProxyBandwidth(t *testing.T) {
+	_, p, o, _ := newTestProxyOptions(t)
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	nc := conn.Underlying()
+
+	p.SetBandwidth(20_000)
+
+	start := time.Now()
+
+	// 20KB at 20KB/s should take about a second
+	err = nc.Publish("bandwidth", make([]byte, 20_000))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nc.Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected sending to be limited, took %v", elapsed)
+	}
+}
+
+func TestProxyReset(t *testing.T) {
+	_, p, o, e := newTestProxyOptions(t)
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	p.Reset()
+
+	e.wait(t, "reconnected", 5*time.Second)
+
+	assertEvents(t, e, "disconnected", "reconnected")
+	assertRoundTrip(t, conn.Underlying())
+}
+
+func TestProxyPartition(t *testing.T) {
+	t.Run("longer than MaxReconnects", func(t *testing.T) {
+		_, p, o, e := newTestProxyOptions(t)
+
+		o.MaxReconnects = 3
+		o.ReconnectWait = 200 * time.Millisecond
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		p.Partition()
+
+		e.wait(t, "closed", 10*time.Second)
+
+		// nats.go calls the disconnect handler again when it gives up
+		assertEvents(t, e, "disconnected", "disconnected", "closed")
+
+		// Every attempt after the first waits for ReconnectWait
+		if elapsed := e.Time("closed").Sub(e.Time("disconnected")); elapsed < 2*o.ReconnectWait {
+			t.Errorf("expected reconnecting to take at least %v, took %v", 2*o.ReconnectWait, elapsed)
+		}
+	})
+
+	t.Run("healed", func(t *testing.T) {
+		_, p, o, e := newTestProxyOptions(t)
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		p.Partition()
+
+		e.wait(t, "disconnected", 5*time.Second)
+
+		time.Sleep(500 * time.Millisecond)
+
+		p.Heal()
+
+		e.wait(t, "reconnected", 5*time.Second)
+
+		assertEvents(t, e, "disconnected", "reconnected")
+		assertRoundTrip(t, conn.Underlying())
+	})
+}
+
+func TestProxyHalfOpen(t *testing.T) {
+	_, p, o, e := newTestProxyOptions(t)
+
+	// Detect the stale connection quickly
+	o.ConnectionTimeout = 500 * time.Millisecond
+	o.AdditionalOptions = []nats.Option{
+		nats.PingInterval(100 * time.Millisecond),
+		nats.MaxPingsOutstanding(2),
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	p.SetHalfOpen(true)
+
+	e.wait(t, "disconnected", 5*time.Second)
+
+	if !conn.Underlying().IsReconnecting() {
+		t.Error("expected to be reconnecting")
+	}
+
+	p.Heal()
+
+	e.wait(t, "reconnected", 5*time.Second)
+
+	assertEvents(t, e, "disconnected", "reconnected")
+	assertRoundTrip(t, conn.Underlying())
+}
+
+func TestProxyMessageLoss(t *testing.T) {
+	s, p, o, e := newTestProxyOptions(t)
+
+	o.ReconnectWait = 50 * time.Millisecond
+
+	publisher, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer publisher.Close()
+
+	// The subscriber connects directly so that it isn't affected
+	subOptions, err := s.NATSOptions(UserConfig{Name: "subscriber"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriber, err := subOptions.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer subscriber.Close()
+
+	sub, err := subscriber.Underlying().SubscribeSync("loss")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = subscriber.Underlying().Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const messages = 500
+
+	for i := 1; i <= messages; i++ {
+		if i == messages/2 {
+			p.Reset()
+		}
+
+		// Messages published while disconnected are buffered and sent when
+		// reconnected
+		err = publisher.Underlying().Publish("loss", []byte(strconv.Itoa(i)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	e.wait(t, "reconnected", 5*time.Second)
+
+	err = publisher.Underlying().Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received int
+	var last int
+
+	for {
+		msg, err := sub.NextMsg(500 * time.Millisecond)
+
+		if err != nil {
+			break
+		}
+
+		n, _ := strconv.Atoi(string(msg.Data))
+
+		if n <= last {
+			t.Fatalf("expected messages in order without duplicates, got %v after %v", n, last)
+		}
+
+		last = n
+		received++
+	}
+
+	// Only messages that were in flight when the connection was reset should
+	// be lost
+	if lost := messages - received; lost > messages/10 {
+		t.Errorf("expected at most %v messages to be lost, lost %v", messages/10, lost)
+	}
+
+	if last != messages {
+		t.Errorf("expected the last message to arrive, got %v", last)
+	}
+}
@@ -0,0 +1,205 @@
+// Package connecttest Provides an in-process NATS server, set up in operator
+// mode in the same way as Overmind's NATS servers, so that code which uses
+// `connect` can be tested without any external services
+package connecttest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+)
+
+// ReadyTimeout How long to wait for the server to accept connections
+const ReadyTimeout = 5 * time.Second
+
+// Server An in-process NATS server in operator mode with a single account.
+// User JWTs are issued by the account's signing key, like those from the
+// Overmind token exchange
+type Server struct {
+	// The URL that clients should connect to
+	URL string
+
+	OperatorKeys nkeys.KeyPair
+	AccountKeys  nkeys.KeyPair
+	SigningKeys  nkeys.KeyPair
+
+	OperatorJWT string
+	AccountJWT  string
+
+	nats *server.Server
+}
+
+// NewServer Generates operator, account and signing keys and starts a server
+// that trusts them, listening on a random port on localhost. The server must
+// be closed using `Close()`
+func NewServer() (*Server, error) {
+	s := Server{}
+
+	var err error
+
+	s.OperatorKeys, err = nkeys.CreateOperator()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.AccountKeys, err = nkeys.CreateAccount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.SigningKeys, err = nkeys.CreateAccount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	operatorPubKey, _ := s.OperatorKeys.PublicKey()
+	accountPubKey, _ := s.AccountKeys.PublicKey()
+	signingPubKey, _ := s.SigningKeys.PublicKey()
+
+	operatorClaims := jwt.NewOperatorClaims(operatorPubKey)
+	operatorClaims.Name = "connecttest"
+
+	s.OperatorJWT, err = operatorClaims.Encode(s.OperatorKeys)
+
+	if err != nil {
+		return nil, fmt.Errorf("encoding operator JWT failed: %w", err)
+	}
+
+	accountClaims := jwt.NewAccountClaims(accountPubKey)
+	accountClaims.Name = "connecttest"
+	accountClaims.SigningKeys.Add(signingPubKey)
+
+	s.AccountJWT, err = accountClaims.Encode(s.OperatorKeys)
+
+	if err != nil {
+		return nil, fmt.Errorf("encoding account JWT failed: %w", err)
+	}
+
+	// The server wants claims that have been through encoding, since that
+	// populates the ID and issuer
+	trusted, err := jwt.DecodeOperatorClaims(s.OperatorJWT)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := &server.MemAccResolver{}
+
+	err = resolver.Store(accountPubKey, s.AccountJWT)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.nats, err = server.NewServer(&server.Options{
+		Host:             "127.0.0.1",
+		Port:             -1,
+		NoLog:            true,
+		NoSigs:           true,
+		TrustedOperators: []*jwt.OperatorClaims{trusted},
+		AccountResolver:  resolver,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	go s.nats.Start()
+
+	if !s.nats.ReadyForConnections(ReadyTimeout) {
+		s.nats.Shutdown()
+
+		return nil, errors.New("NATS server not ready for connections")
+	}
+
+	s.URL = s.nats.ClientURL()
+
+	return &s, nil
+}
+
+// Start Starts a server for the duration of a test, failing the test if it
+// can't be started
+func Start(t testing.TB) *Server {
+	t.Helper()
+
+	s, err := NewServer()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Close Shuts down the server, disconnecting all clients
+func (s *Server) Close() {
+	s.nats.Shutdown()
+	s.nats.WaitForShutdown()
+}
+
+// NATSServer Returns the underlying server, e.g. to inspect connections
+func (s *Server) NATSServer() *server.Server {
+	return s.nats
+}
+
+// AccountPublicKey Returns the public key of the account that users belong to
+func (s *Server) AccountPublicKey() string {
+	pubKey, _ := s.AccountKeys.PublicKey()
+
+	return pubKey
+}
+
+// UserJWT Issues a user JWT for the supplied public key
+func (s *Server) UserJWT(pubKey string, config UserConfig) (string, error) {
+	claims := config.userClaims(pubKey)
+	claims.IssuerAccount = s.AccountPublicKey()
+
+	return claims.Encode(s.SigningKeys)
+}
+
+// NewTokenClient Creates a token client for a new user, which gets JWTs from
+// this server
+func (s *Server) NewTokenClient(config UserConfig) (*TokenClient, error) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenClient{
+		server: s,
+		config: config,
+		keys:   keys,
+	}, nil
+}
+
+// NATSOptions Returns options that connect to this server as a new user.
+// Retries and reconnects are fast, since the server is local
+func (s *Server) NATSOptions(config UserConfig) (connect.NATSOptions, error) {
+	client, err := s.NewTokenClient(config)
+
+	if err != nil {
+		return connect.NATSOptions{}, err
+	}
+
+	return connect.NATSOptions{
+		Servers:           []string{s.URL},
+		ConnectionName:    config.Name,
+		TokenClient:       client,
+		ConnectionTimeout: time.Second,
+		ReconnectWait:     100 * time.Millisecond,
+		ReconnectJitter:   time.Millisecond,
+		RetryDelay:        100 * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,228 @@
+package connecttest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+)
+
+func TestServer(t *testing.T) {
+	s := Start(t)
+
+	o, err := s.NATSOptions(UserConfig{
+		Name: "test-user",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	nc := conn.Underlying()
+
+	sub, err := nc.SubscribeSync("test.subject")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nc.Publish("test.subject", []byte("hello"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(msg.Data) != "hello" {
+		t.Errorf("expected hello, got %v", string(msg.Data))
+	}
+}
+
+func TestUserJWT(t *testing.T) {
+	s := Start(t)
+
+	client, err := s.NewTokenClient(UserConfig{
+		Name:          "test-user",
+		Expiry:        time.Hour,
+		Publish:       []string{"request.>"},
+		DenySubscribe: []string{"secret.>"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := client.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != client.PublicKey() {
+		t.Errorf("expected subject %v, got %v", client.PublicKey(), claims.Subject)
+	}
+
+	if claims.IssuerAccount != s.AccountPublicKey() {
+		t.Errorf("expected issuer account %v, got %v", s.AccountPublicKey(), claims.IssuerAccount)
+	}
+
+	if claims.Expires == 0 {
+		t.Error("expected JWT to expire")
+	}
+
+	// The JWT should be trusted through the account's signing key
+	verifier := connect.JWTVerifier{
+		TrustedAccounts: []string{s.AccountPublicKey()},
+		AccountJWTs:     []string{s.AccountJWT},
+	}
+
+	_, err = verifier.Verify(token, client.PublicKey())
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The same JWT is returned until it is invalidated
+	again, _ := client.GetJWT()
+
+	if again != token {
+		t.Error("expected the JWT to be reused")
+	}
+
+	client.InvalidateJWT()
+
+	_, err = client.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if minted := client.Minted(); minted != 2 {
+		t.Errorf("expected 2 JWTs to be minted, got %v", minted)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	s := Start(t)
+
+	o, err := s.NATSOptions(UserConfig{
+		Name:      "restricted",
+		Publish:   []string{"allowed.>"},
+		Subscribe: []string{"allowed.>", "_INBOX.>"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("checked before connecting", func(t *testing.T) {
+		o := o
+		o.SubscribeSubjects = []string{"denied.subject"}
+
+		_, err := o.Connect()
+
+		var permErr connect.PermissionsError
+
+		if !errors.As(err, &permErr) {
+			t.Errorf("expected a PermissionsError, got %v", err)
+		}
+	})
+
+	t.Run("enforced by the server", func(t *testing.T) {
+		violations := make(chan error, 1)
+
+		o := o
+		o.ErrorHandler = func(c *nats.Conn, s *nats.Subscription, err error) {
+			violations <- err
+		}
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		_, err = conn.Underlying().SubscribeSync("denied.subject")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-violations:
+			if !strings.Contains(strings.ToLower(err.Error()), nats.PERMISSIONS_ERR) {
+				t.Errorf("expected a permissions violation, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("expected the server to reject the subscription")
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	s := Start(t)
+
+	client, err := s.NewTokenClient(UserConfig{
+		Name:   "short-lived",
+		Expiry: 2 * time.Second,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconnected := make(chan struct{}, 10)
+
+	o := connect.NATSOptions{
+		Servers:         []string{s.URL},
+		TokenClient:     client,
+		ReconnectWait:   100 * time.Millisecond,
+		ReconnectJitter: time.Millisecond,
+		ReconnectHandler: func(c *nats.Conn) {
+			reconnected <- struct{}{}
+		},
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting to reconnect")
+	}
+
+	if minted := client.Minted(); minted < 2 {
+		t.Errorf("expected a new JWT to be used to reconnect, got %v JWTs", minted)
+	}
+}
@@ -0,0 +1,124 @@
+package connecttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// UserConfig Describes the user JWTs to issue
+type UserConfig struct {
+	// The name of the user, also used as the connection name
+	Name string
+
+	// How long each JWT is valid for. JWTs don't expire if this is zero
+	Expiry time.Duration
+
+	// Subjects the user may publish and subscribe to. If empty, all subjects
+	// are allowed
+	Publish   []string
+	Subscribe []string
+
+	// Subjects the user may not publish or subscribe to
+	DenyPublish   []string
+	DenySubscribe []string
+
+	// Tags to add to the JWT
+	Tags []string
+}
+
+// userClaims Returns the claims of a JWT for `pubKey` that has this config
+func (config UserConfig) userClaims(pubKey string) *jwt.UserClaims {
+	claims := jwt.NewUserClaims(pubKey)
+	claims.Name = config.Name
+	claims.Pub.Allow.Add(config.Publish...)
+	claims.Pub.Deny.Add(config.DenyPublish...)
+	claims.Sub.Allow.Add(config.Subscribe...)
+	claims.Sub.Deny.Add(config.DenySubscribe...)
+	claims.Tags.Add(config.Tags...)
+
+	if config.Expiry != 0 {
+		claims.Expires = time.Now().Add(config.Expiry).Unix()
+	}
+
+	return claims
+}
+
+// TokenClient A `connect.TokenClient` that mints JWTs from a `Server` on
+// demand. A new JWT is minted when the current one expires or is invalidated
+type TokenClient struct {
+	server *Server
+	config UserConfig
+	keys   nkeys.KeyPair
+
+	mutex  sync.Mutex
+	jwt    string
+	expiry time.Time
+	minted int
+}
+
+func (c *TokenClient) GetJWT() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.jwt != "" && (c.expiry.IsZero() || time.Now().Before(c.expiry)) {
+		return c.jwt, nil
+	}
+
+	pubKey, err := c.keys.PublicKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	token, err := c.server.UserJWT(pubKey, c.config)
+
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		return "", err
+	}
+
+	c.jwt = token
+	c.expiry = time.Time{}
+	c.minted++
+
+	if claims.Expires != 0 {
+		c.expiry = time.Unix(claims.Expires, 0)
+	}
+
+	return c.jwt, nil
+}
+
+func (c *TokenClient) Sign(in []byte) ([]byte, error) {
+	return c.keys.Sign(in)
+}
+
+// InvalidateJWT Forces a new JWT to be minted next time one is requested
+func (c *TokenClient) InvalidateJWT() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.jwt = ""
+}
+
+// PublicKey Returns the user's public key
+func (c *TokenClient) PublicKey() string {
+	pubKey, _ := c.keys.PublicKey()
+
+	return pubKey
+}
+
+// Minted Returns how many JWTs have been minted
+func (c *TokenClient) Minted() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.minted
+}
@@ -0,0 +1,111 @@
+package connect
+
+import (
+	"context"
+)
+
+// ContextTokenClient A variant of `TokenClient` that accepts a context, so that
+// getting a token can be traced as part of the caller's trace, and cancelled or
+// given a deadline
+type ContextTokenClient interface {
+	// Returns a NATS token that can be used to connect
+	GetJWTContext(ctx context.Context) (string, error)
+
+	// Uses the NKeys associated with the token to sign a nonce
+	SignContext(ctx context.Context, nonce []byte) ([]byte, error)
+}
+
+// AsContextTokenClient Returns the token client as a `ContextTokenClient`. If
+// it doesn't already implement the interface, it is wrapped so that the context
+// is only checked for cancellation before each call
+func AsContextTokenClient(client TokenClient) ContextTokenClient {
+	if c, ok := client.(ContextTokenClient); ok {
+		return c
+	}
+
+	return &contextAdapter{
+		client: client,
+	}
+}
+
+// AsTokenClient Returns the context token client as a `TokenClient`. If it
+// doesn't already implement the interface, it is wrapped so that each call
+// uses a background context
+func AsTokenClient(client ContextTokenClient) TokenClient {
+	if c, ok := client.(TokenClient); ok {
+		return c
+	}
+
+	return &tokenAdapter{
+		client: client,
+	}
+}
+
+// contextAdapter Adapts a `TokenClient` to a `ContextTokenClient`
+type contextAdapter struct {
+	client TokenClient
+}
+
+func (c *contextAdapter) GetJWTContext(ctx context.Context) (string, error) {
+	err := ctx.Err()
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.client.GetJWT()
+}
+
+func (c *contextAdapter) SignContext(ctx context.Context, nonce []byte) ([]byte, error) {
+	err := ctx.Err()
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return c.client.Sign(nonce)
+}
+
+// tokenAdapter Adapts a `ContextTokenClient` to a `TokenClient`. It still
+// implements `ContextTokenClient` so that callers which can supply a context,
+// such as `NATSOptions.ConnectContext`, can reach the wrapped client
+type tokenAdapter struct {
+	client ContextTokenClient
+}
+
+func (t *tokenAdapter) GetJWTContext(ctx context.Context) (string, error) {
+	return t.client.GetJWTContext(ctx)
+}
+
+func (t *tokenAdapter) SignContext(ctx context.Context, nonce []byte) ([]byte, error) {
+	return t.client.SignContext(ctx, nonce)
+}
+
+func (t *tokenAdapter) GetJWT() (string, error) {
+	return t.client.GetJWTContext(context.Background())
+}
+
+func (t *tokenAdapter) Sign(in []byte) ([]byte, error) {
+	return t.client.SignContext(context.Background(), in)
+}
+
+// contextSigner Is implemented by signers that accept a context, such as
+// `SocketSigner`
+type contextSigner interface {
+	SignContext(ctx context.Context, in []byte) ([]byte, error)
+}
+
+// signContext Signs using the signer, passing the context if it supports one
+func signContext(ctx context.Context, signer Signer, in []byte) ([]byte, error) {
+	if s, ok := signer.(contextSigner); ok {
+		return s.SignContext(ctx, in)
+	}
+
+	err := ctx.Err()
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return signer.Sign(in)
+}
@@ -0,0 +1,60 @@
+package connect_test
+
+import (
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestAsTokenClient(t *testing.T) {
+	m, err := connecttest.NewMockTokenClient(connecttest.UserConfig{Name: t.Name()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hide the mock's TokenClient methods so that it has to be adapted
+	c := connect.AsTokenClient(struct{ connect.ContextTokenClient }{m})
+
+	token, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if jwts := m.JWTs(); len(jwts) != 1 || token != jwts[0] {
+		t.Errorf("expected the mock's JWT, got %v", token)
+	}
+
+	data := []byte{1, 156, 230, 4}
+
+	signed, err := c.Sign(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := nkeys.FromPublicKey(m.PublicKey())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pubKey.Verify(data, signed)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if contexts := m.Contexts(); len(contexts) != 2 {
+		t.Errorf("expected both calls to use the context methods, got %v", len(contexts))
+	}
+
+	oauth := connect.NewOAuthTokenClientWithTokenSource("http://localhost", "", nil)
+
+	if connect.AsTokenClient(oauth) != connect.TokenClient(oauth) {
+		t.Error("expected OAuthTokenClient not to be wrapped")
+	}
+}
@@ -0,0 +1,70 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+func TestContextAdapters(t *testing.T) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("AsContextTokenClient", func(t *testing.T) {
+		c := AsContextTokenClient(NewBasicTokenClient("token", keys))
+
+		token, err := c.GetJWTContext(context.Background())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "token" {
+			t.Errorf("expected token, got %v", token)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if _, err = c.GetJWTContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		if _, err = c.SignContext(ctx, []byte{1}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		// Clients that already support contexts aren't wrapped
+		oauth := NewOAuthTokenClientWithTokenSource("http://localhost", "", nil)
+
+		if AsContextTokenClient(oauth) != ContextTokenClient(oauth) {
+			t.Error("expected OAuthTokenClient not to be wrapped")
+		}
+	})
+}
+
+func TestNATSConnectContext(t *testing.T) {
+	t.Run("with a cancelled context", func(t *testing.T) {
+		o := NATSOptions{
+			Servers:    []string{"nats://badname.dontresolve.com"},
+			NumRetries: -1,
+			RetryDelay: 100 * time.Millisecond,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		// This would retry forever without the context
+		_, err := o.ConnectContext(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
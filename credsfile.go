@@ -0,0 +1,109 @@
+package connect
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// CredsFileTokenClient Reads the JWT and NKey seed from a NATS `.creds` file,
+// such as one mounted from a Kubernetes secret. The file is read again each
+// time a JWT is requested so that rotated credentials are picked up
+type CredsFileTokenClient struct {
+	path string
+
+	mutex  sync.Mutex
+	jwt    string
+	keys   nkeys.KeyPair
+	closed bool
+}
+
+// NewCredsFileTokenClient Creates a token client that reads credentials from
+// the `.creds` file at `path`
+func NewCredsFileTokenClient(path string) *CredsFileTokenClient {
+	return &CredsFileTokenClient{
+		path: path,
+	}
+}
+
+// load Reads the JWT and keys from the creds file
+func (c *CredsFileTokenClient) load() error {
+	contents, err := os.ReadFile(c.path)
+
+	if err != nil {
+		return fmt.Errorf("reading creds file failed: %w", err)
+	}
+
+	token, err := jwt.ParseDecoratedJWT(contents)
+
+	if err != nil {
+		return fmt.Errorf("parsing JWT from creds file failed: %w", err)
+	}
+
+	keys, err := jwt.ParseDecoratedUserNKey(contents)
+
+	if err != nil {
+		return fmt.Errorf("parsing NKey from creds file failed: %w", err)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		return fmt.Errorf("decoding JWT from creds file failed: %w", err)
+	}
+
+	pubKey, err := keys.PublicKey()
+
+	if err != nil {
+		return err
+	}
+
+	if claims.Subject != pubKey {
+		return errors.New("JWT in creds file was not issued for the NKey in the creds file")
+	}
+
+	c.jwt = token
+	c.keys = keys
+
+	return nil
+}
+
+func (c *CredsFileTokenClient) GetJWT() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return "", ErrTokenClientClosed
+	}
+
+	err := c.load()
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.jwt, nil
+}
+
+func (c *CredsFileTokenClient) Sign(in []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return []byte{}, ErrTokenClientClosed
+	}
+
+	if c.keys == nil {
+		err := c.load()
+
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+
+	return c.keys.Sign(in)
+}
@@ -0,0 +1,137 @@
+package connect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// writeTestCredsFile Writes a creds file containing a new user JWT and seed,
+// returning the user's keys
+func writeTestCredsFile(t *testing.T, path string) nkeys.KeyPair {
+	t.Helper()
+
+	accountKeys, err := nkeys.CreateAccount()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userKeys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := userKeys.PublicKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.NewUserClaims(pubKey)
+	claims.Expires = time.Now().Add(time.Hour).Unix()
+
+	token, err := claims.Encode(accountKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed, err := userKeys.Seed()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := jwt.FormatUserConfig(token, seed)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, creds, 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userKeys
+}
+
+func TestCredsFileTokenClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.creds")
+
+	t.Run("with a missing file", func(t *testing.T) {
+		c := NewCredsFileTokenClient(path)
+
+		_, err := c.GetJWT()
+
+		if err == nil {
+			t.Error("expected an error for a missing creds file")
+		}
+	})
+
+	t.Run("with a valid file", func(t *testing.T) {
+		keys := writeTestCredsFile(t, path)
+
+		c := NewCredsFileTokenClient(path)
+
+		token, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := jwt.DecodeUserClaims(token)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubKey, _ := keys.PublicKey()
+
+		if claims.Subject != pubKey {
+			t.Errorf("expected subject %v, got %v", pubKey, claims.Subject)
+		}
+
+		data := []byte{1, 156, 230, 4, 23, 175, 11}
+
+		signed, err := c.Sign(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = keys.Verify(data, signed)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		// Rotate the credentials, the new ones should be picked up
+		newKeys := writeTestCredsFile(t, path)
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err = c.Sign(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = newKeys.Verify(data, signed)
+
+		if err != nil {
+			t.Error("expected rotated keys to be used for signing")
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/oauth2"
+)
+
+// DeviceFlowConfig Authenticates to Overmind using the OAuth 2.0 Device
+// Authorization Grant (RFC 8628). This is intended for interactive CLIs where
+// the user completes the login in a browser, possibly on another device
+// https://auth0.com/docs/get-started/authentication-and-authorization-flow/device-authorization-flow
+type DeviceFlowConfig struct {
+	// The ClientID of the application that the user will be logging in to
+	ClientID string
+	// The device authorization endpoint e.g.
+	// https://auth.overmind.tech/oauth/device/code
+	DeviceAuthURL string
+	// The token endpoint e.g. https://auth.overmind.tech/oauth/token
+	TokenURL string
+	// Scopes to request. Include `offline_access` if the access token should
+	// be refreshed without prompting the user again
+	Scopes []string
+	// The audience to request a token for. Defaults to `DefaultAudience`
+	Audience string
+	// The account to request a NATS token for. This has the same meaning as
+	// `ClientCredentialsConfig.Account`
+	Account string
+	// Where the verification URL and user code will be printed. Defaults to
+	// os.Stderr
+	Output io.Writer
+	// The token revocation endpoint (RFC 7009). If set, the user's token is
+	// revoked when the client is closed
+	RevocationURL string
+}
+
+// DeviceFlowTokenClient Gets a NATS token by first authenticating the user
+// using the OAuth Device Authorization Flow, then using that token to retrieve
+// a NATS token in the same way as `OAuthTokenClient`
+type DeviceFlowTokenClient struct {
+	*OAuthTokenClient
+}
+
+// NewDeviceFlowTokenClient Generates a token client that authenticates the
+// user interactively using the device authorization flow. The user will be
+// prompted the first time a token is required, and again if the access token
+// expires and can't be refreshed. `overmindAPIURL` is the root URL of the NATS
+// token exchange API e.g. https://api.server.test/v1
+func NewDeviceFlowTokenClient(overmindAPIURL string, flowConfig DeviceFlowConfig) *DeviceFlowTokenClient {
+	audience := flowConfig.Audience
+
+	if audience == "" {
+		audience = DefaultAudience
+	}
+
+	output := flowConfig.Output
+
+	if output == nil {
+		output = os.Stderr
+	}
+
+	d := &deviceFlow{
+		config: &oauth2.Config{
+			ClientID: flowConfig.ClientID,
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: flowConfig.DeviceAuthURL,
+				TokenURL:      flowConfig.TokenURL,
+			},
+			Scopes: flowConfig.Scopes,
+		},
+		audience: audience,
+		output:   output,
+	}
+
+	ts := newInteractiveTokenSource(d.config, d.authenticate, flowConfig.RevocationURL)
+
+	return &DeviceFlowTokenClient{
+		OAuthTokenClient: NewOAuthTokenClientWithTokenSource(overmindAPIURL, flowConfig.Account, ts),
+	}
+}
+
+// deviceFlow Holds the config required to run the device flow
+type deviceFlow struct {
+	config   *oauth2.Config
+	audience string
+	output   io.Writer
+}
+
+// authenticate Runs the device flow, printing instructions for the user and
+// then polling the token endpoint until the user has logged in
+func (d *deviceFlow) authenticate(ctx context.Context) (*oauth2.Token, error) {
+	audienceOption := oauth2.SetAuthURLParam("audience", d.audience)
+
+	deviceCode, err := d.config.DeviceAuth(ctx, audienceOption)
+
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+
+	if deviceCode.VerificationURI == "" || deviceCode.UserCode == "" {
+		return nil, errors.New("device authorization response did not contain a verification URI and user code")
+	}
+
+	fmt.Fprintf(d.output, "To authenticate, visit %v and enter the code: %v\n", deviceCode.VerificationURI, deviceCode.UserCode)
+
+	if deviceCode.VerificationURIComplete != "" {
+		fmt.Fprintf(d.output, "Alternatively, open: %v\n", deviceCode.VerificationURIComplete)
+	}
+
+	token, err := d.config.DeviceAccessToken(ctx, deviceCode, audienceOption)
+
+	if err != nil {
+		return nil, fmt.Errorf("waiting for device authorization failed: %w", err)
+	}
+
+	return token, nil
+}
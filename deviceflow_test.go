@@ -0,0 +1,96 @@
+package connect_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestDeviceFlowTokenClient(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	api.SetPendingPolls(1)
+
+	var output bytes.Buffer
+
+	c := connect.NewDeviceFlowTokenClient(api.ExchangeURL, connect.DeviceFlowConfig{
+		ClientID:      connecttest.ClientID,
+		DeviceAuthURL: api.DeviceAuthURL,
+		TokenURL:      api.OAuthURL,
+		Output:        &output,
+	})
+
+	token, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(output.String(), api.URL+"/activate") {
+		t.Errorf("expected verification URL to be printed, got: %v", output.String())
+	}
+
+	requests := api.Requests(connecttest.EndpointDeviceCode)
+
+	if len(requests) != 1 || requests[0].Form.Get("audience") != connect.DefaultAudience {
+		t.Errorf("expected the device code to be requested for the default audience, got %v", requests)
+	}
+
+	// The first poll is told to wait
+	if polls := len(api.Requests(connecttest.EndpointOAuth)); polls != 2 {
+		t.Errorf("expected 2 polls, got %v", polls)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := c.Signer.PublicKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != pubKey {
+		t.Errorf("expected token subject to be %v, got %v", pubKey, claims.Subject)
+	}
+
+	// The token should be cached, so the user isn't prompted again
+	output.Reset()
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if output.Len() != 0 {
+		t.Errorf("expected no further prompts, got: %v", output.String())
+	}
+
+	data := []byte{1, 156, 230, 4, 23, 175, 11}
+
+	signed, err := c.Sign(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKeys, err := nkeys.FromPublicKey(pubKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pubKeys.Verify(data, signed)
+
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,233 @@
+package connect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	connectgo "github.com/bufbuild/connect-go"
+	overmind "github.com/overmindtech/api-client"
+	"golang.org/x/oauth2"
+)
+
+// Errors that a `TokenError` can be matched against using `errors.Is()`
+var (
+	// ErrUnauthorized The credentials were invalid
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden The credentials were valid but don't have permission e.g.
+	// requesting a token for another account without `admin:write`
+	ErrForbidden = errors.New("forbidden")
+	// ErrAccountNotFound The account that a token was explicitly requested for
+	// using `AdminCreateToken` doesn't exist
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrServerUnavailable The server couldn't be reached, was overloaded, or
+	// failed with a 5xx error
+	ErrServerUnavailable = errors.New("server unavailable")
+)
+
+// TokenStep The step of getting a NATS token that failed
+type TokenStep string
+
+const (
+	// TokenStepOAuth Getting an OAuth access token
+	TokenStepOAuth TokenStep = "oauth"
+	// TokenStepAPI Exchanging the OAuth access token for a NATS token using
+	// the Overmind API
+	TokenStepAPI TokenStep = "api"
+)
+
+// TokenError Returned when getting a NATS token fails. Use `errors.Is()` with
+// `ErrUnauthorized`, `ErrForbidden`, `ErrAccountNotFound` or
+// `ErrServerUnavailable` to find out what went wrong, or `errors.As()` to get
+// the details
+type TokenError struct {
+	// Which step failed
+	Step TokenStep
+	// The HTTP status code of the response, or zero if there wasn't one
+	StatusCode int
+	// The error code from the response body e.g. `invalid_client`
+	Code string
+	// The error message from the response body
+	Message string
+	// The raw response body
+	Body []byte
+	// The URL of the request, if known
+	URL string
+	// The underlying error
+	Err error
+
+	// The kind of error, one of the sentinel errors above or nil
+	kind error
+}
+
+func (e *TokenError) Error() string {
+	var description string
+
+	switch e.Step {
+	case TokenStepOAuth:
+		description = fmt.Sprintf("getting OAuth token failed: %v", e.Err)
+	default:
+		description = fmt.Sprintf("getting NATS token failed: %v", e.Err)
+	}
+
+	if e.Message != "" && !strings.Contains(description, e.Message) {
+		description = description + fmt.Sprintf(": %v", e.Message)
+	}
+
+	if e.URL != "" {
+		description = description + fmt.Sprintf(". Request URL: %v", e.URL)
+	}
+
+	return description
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+// Is Allows the error to be matched against the sentinel errors
+func (e *TokenError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+// errorKind Returns the sentinel error that corresponds to an HTTP status code.
+// A 404 only means that the account doesn't exist if the request was for an
+// account
+func errorKind(statusCode int, forAccount bool) error {
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case statusCode == http.StatusForbidden:
+		return ErrForbidden
+	case statusCode == http.StatusNotFound && forAccount:
+		return ErrAccountNotFound
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return ErrServerUnavailable
+	}
+
+	return nil
+}
+
+// newOAuthError Converts an error from an `oauth2.TokenSource` into a
+// `TokenError`, extracting whatever details are available
+func newOAuthError(err error) *TokenError {
+	tokenErr := &TokenError{
+		Step: TokenStepOAuth,
+		Err:  err,
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	var connectErr *connectgo.Error
+	var urlErr *url.Error
+
+	switch {
+	case errors.As(err, &retrieveErr):
+		tokenErr.Code = retrieveErr.ErrorCode
+		tokenErr.Message = retrieveErr.ErrorDescription
+		tokenErr.Body = retrieveErr.Body
+
+		if retrieveErr.Response != nil {
+			tokenErr.StatusCode = retrieveErr.Response.StatusCode
+
+			if retrieveErr.Response.Request != nil && retrieveErr.Response.Request.URL != nil {
+				tokenErr.URL = retrieveErr.Response.Request.URL.String()
+			}
+		}
+
+		tokenErr.kind = errorKind(tokenErr.StatusCode, false)
+
+		// Some servers return a 400 for bad credentials
+		switch retrieveErr.ErrorCode {
+		case "invalid_client", "invalid_grant":
+			tokenErr.kind = ErrUnauthorized
+		case "unauthorized_client", "access_denied":
+			tokenErr.kind = ErrForbidden
+		}
+	case errors.As(err, &connectErr):
+		tokenErr.Code = connectErr.Code().String()
+		tokenErr.Message = connectErr.Message()
+
+		switch connectErr.Code() {
+		case connectgo.CodeUnauthenticated:
+			tokenErr.kind = ErrUnauthorized
+		case connectgo.CodePermissionDenied:
+			tokenErr.kind = ErrForbidden
+		case connectgo.CodeUnavailable, connectgo.CodeResourceExhausted, connectgo.CodeInternal:
+			tokenErr.kind = ErrServerUnavailable
+		}
+	case errors.Is(err, ErrRefreshTokenRevoked):
+		tokenErr.kind = ErrUnauthorized
+	case errors.As(err, &urlErr):
+		// The request didn't get a response
+		tokenErr.URL = urlErr.URL
+		tokenErr.kind = ErrServerUnavailable
+	}
+
+	return tokenErr
+}
+
+// newAPIError Converts an error from the Overmind API into a `TokenError`. The
+// response may be nil if the request didn't get a response. `forAccount` is
+// true if the token was requested for an explicit account
+func newAPIError(err error, response *http.Response, forAccount bool) *TokenError {
+	tokenErr := &TokenError{
+		Step: TokenStepAPI,
+		Err:  err,
+	}
+
+	if response == nil {
+		tokenErr.kind = ErrServerUnavailable
+
+		return tokenErr
+	}
+
+	tokenErr.StatusCode = response.StatusCode
+	tokenErr.kind = errorKind(response.StatusCode, forAccount)
+
+	if response.Request != nil && response.Request.URL != nil {
+		tokenErr.URL = response.Request.URL.String()
+	}
+
+	var apiErr *overmind.GenericOpenAPIError
+
+	if errors.As(err, &apiErr) {
+		tokenErr.Body = apiErr.Body()
+		tokenErr.Code, tokenErr.Message = parseErrorBody(apiErr.Body())
+	}
+
+	return tokenErr
+}
+
+// parseErrorBody Extracts the error code and message from a JSON error body,
+// falling back to the body as text if it isn't JSON
+func parseErrorBody(body []byte) (string, string) {
+	var parsed struct {
+		Error   string `json:"error"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Detail  string `json:"detail"`
+	}
+
+	err := json.Unmarshal(body, &parsed)
+
+	if err != nil {
+		return "", strings.TrimSpace(string(body))
+	}
+
+	code := parsed.Code
+
+	if code == "" {
+		code = parsed.Error
+	}
+
+	message := parsed.Message
+
+	if message == "" {
+		message = parsed.Detail
+	}
+
+	return code, message
+}
@@ -0,0 +1,130 @@
+package connect_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestTokenErrors(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	t.Run("API errors", func(t *testing.T) {
+		tests := []struct {
+			Status   int
+			Expected error
+		}{
+			{http.StatusUnauthorized, connect.ErrUnauthorized},
+			{http.StatusForbidden, connect.ErrForbidden},
+			{http.StatusNotFound, connect.ErrAccountNotFound},
+			{http.StatusServiceUnavailable, connect.ErrServerUnavailable},
+		}
+
+		for _, test := range tests {
+			t.Run(http.StatusText(test.Status), func(t *testing.T) {
+				api.Fail(connecttest.EndpointAdminCreateToken, test.Status)
+
+				c := api.NewTokenClient("test-account")
+				c.Retry.MaxAttempts = 1
+
+				_, err := c.GetJWT()
+
+				if !errors.Is(err, test.Expected) {
+					t.Errorf("expected %v, got %v", test.Expected, err)
+				}
+
+				var tokenErr *connect.TokenError
+
+				if !errors.As(err, &tokenErr) {
+					t.Fatalf("expected a TokenError, got %T", err)
+				}
+
+				if tokenErr.Step != connect.TokenStepAPI {
+					t.Errorf("expected API step, got %v", tokenErr.Step)
+				}
+
+				if tokenErr.StatusCode != test.Status {
+					t.Errorf("expected status %v, got %v", test.Status, tokenErr.StatusCode)
+				}
+
+				expectedCode := strings.ToLower(strings.ReplaceAll(http.StatusText(test.Status), " ", "_"))
+
+				if tokenErr.Code != expectedCode || tokenErr.Message != "scripted failure" {
+					t.Errorf("expected parsed body, got %q %q", tokenErr.Code, tokenErr.Message)
+				}
+
+				if tokenErr.URL == "" {
+					t.Error("expected request URL")
+				}
+			})
+		}
+	})
+
+	t.Run("CreateToken 404", func(t *testing.T) {
+		api.Fail(connecttest.EndpointCreateToken, http.StatusNotFound)
+
+		c := api.NewTokenClient("")
+		c.Retry.MaxAttempts = 1
+
+		_, err := c.GetJWT()
+
+		var tokenErr *connect.TokenError
+
+		if !errors.As(err, &tokenErr) || tokenErr.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected a 404 TokenError, got %v", err)
+		}
+
+		// No account was requested, so it can't be missing
+		if errors.Is(err, connect.ErrAccountNotFound) {
+			t.Error("expected a 404 without an account not to be ErrAccountNotFound")
+		}
+	})
+
+	t.Run("with the API unreachable", func(t *testing.T) {
+		unreachable := connecttest.StartAPI(t, nil)
+		unreachable.Close()
+
+		c := connect.NewOAuthTokenClient(api.OAuthURL, unreachable.ExchangeURL, connect.ClientCredentialsConfig{
+			ClientID:     connecttest.ClientID,
+			ClientSecret: connecttest.ClientSecret,
+		})
+		c.Retry.MaxAttempts = 1
+
+		_, err := c.GetJWT()
+
+		if !errors.Is(err, connect.ErrServerUnavailable) {
+			t.Errorf("expected ErrServerUnavailable, got %v", err)
+		}
+	})
+
+	t.Run("OAuth errors", func(t *testing.T) {
+		c := connect.NewOAuthTokenClient(api.OAuthURL, api.ExchangeURL, connect.ClientCredentialsConfig{
+			ClientID:     connecttest.ClientID,
+			ClientSecret: "wrong-secret",
+		})
+
+		_, err := c.GetJWT()
+
+		if !errors.Is(err, connect.ErrUnauthorized) {
+			t.Errorf("expected ErrUnauthorized, got %v", err)
+		}
+
+		var tokenErr *connect.TokenError
+
+		if !errors.As(err, &tokenErr) {
+			t.Fatalf("expected a TokenError, got %T", err)
+		}
+
+		if tokenErr.Step != connect.TokenStepOAuth {
+			t.Errorf("expected OAuth step, got %v", tokenErr.Step)
+		}
+
+		if tokenErr.StatusCode != http.StatusUnauthorized || tokenErr.Code != "invalid_client" {
+			t.Errorf("expected 401 invalid_client, got %v %v", tokenErr.StatusCode, tokenErr.Code)
+		}
+	})
+}
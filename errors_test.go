@@ -0,0 +1,28 @@
+package connect
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewOAuthError(t *testing.T) {
+	err := newOAuthError(ErrRefreshTokenRevoked)
+
+	if !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Errorf("expected both ErrUnauthorized and ErrRefreshTokenRevoked, got %v", err)
+	}
+}
+
+func TestParseErrorBody(t *testing.T) {
+	code, message := parseErrorBody([]byte(`{"error": "invalid_request", "detail": "bad"}`))
+
+	if code != "invalid_request" || message != "bad" {
+		t.Errorf("unexpected %q %q", code, message)
+	}
+
+	code, message = parseErrorBody([]byte("upstream connect error\n"))
+
+	if code != "" || message != "upstream connect error" {
+		t.Errorf("unexpected %q %q", code, message)
+	}
+}
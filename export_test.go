@@ -0,0 +1,76 @@
+package connect
+
+import "time"
+
+// Internals that the tests in `connect_test` need. Those tests can't be in
+// this package since they use connecttest, which imports it
+
+// SetJWT Replaces the cached JWT
+func (o *OAuthTokenClient) SetJWT(token string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.jwt = token
+}
+
+// CachedJWT Returns the cached JWT without checking it
+func (o *OAuthTokenClient) CachedJWT() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.jwt
+}
+
+// ExpireAccessToken Pretends that the access token was fetched an hour ago and
+// expires after `in`, including in any token source that caches it
+func (o *OAuthTokenClient) ExpireAccessToken(in time.Duration) {
+	fetched := time.Now().Add(-time.Hour)
+	expiry := time.Now().Add(in)
+
+	o.mutex.Lock()
+	o.accessTokenFetched = fetched
+	o.accessTokenExpiry = expiry
+	o.mutex.Unlock()
+
+	switch s := o.tokenSource.(type) {
+	case *refreshTokenSource:
+		s.mutex.Lock()
+		s.fetched = fetched
+		s.token.Expiry = expiry
+		s.mutex.Unlock()
+	case *tokenExchangeSource:
+		s.mutex.Lock()
+		s.fetched = fetched
+		s.token.Expiry = expiry
+		s.mutex.Unlock()
+	}
+}
+
+// AccessTokenExpiry Returns when the access token that was used to get the
+// current JWT expires
+func (o *OAuthTokenClient) AccessTokenExpiry() time.Time {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.accessTokenExpiry
+}
+
+// AccessTokenFetched Returns when the access token that was used to get the
+// current JWT was first seen
+func (o *OAuthTokenClient) AccessTokenFetched() time.Time {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.accessTokenFetched
+}
+
+// NewTestSocketSigner Serves a local signer on a Unix socket and returns a
+// signer that uses it, along with the local signer's public key
+var NewTestSocketSigner = newTestSocketSigner
+
+// NewTestAccessToken Creates an unsigned JWT access token with the given
+// expiry
+var NewTestAccessToken = testAccessToken
+
+// OptionsToStruct Applies NATS options to an empty `nats.Options`
+var OptionsToStruct = optionsToStruct
@@ -0,0 +1,90 @@
+package connect
+
+import (
+	"os"
+	"strings"
+	"text/template"
+)
+
+// IdentityNameTemplateDefault The template that is used to name clients if
+// no other template is specified
+const IdentityNameTemplateDefault = "{{.Hostname}}"
+
+// PodNameEnvVar The environment variable that the pod name is read from if it
+// isn't set explicitly. This can be populated using the Kubernetes downward API
+const PodNameEnvVar = "POD_NAME"
+
+// Identity Describes the client that is connecting. This is used as the user
+// name when requesting NATS tokens, which is shown in the NATS server's
+// connection list and in audit logs, and can also be used as the NATS
+// connection name
+type Identity struct {
+	// A text/template that generates the name. The available fields are
+	// `.Hostname`, `.PodName`, `.ServiceName`, `.Version` and `.Tags` e.g.
+	// `{{.ServiceName}}@{{.Version}} ({{.PodName}})`. Defaults to
+	// `IdentityNameTemplateDefault`
+	NameTemplate string
+	// The name of the service that is connecting
+	ServiceName string
+	// The version of the service that is connecting
+	Version string
+	// The name of the pod that is connecting. Defaults to the value of the
+	// `POD_NAME` environment variable
+	PodName string
+	// Extra tags that describe the client. The token API doesn't currently
+	// accept tags, so these are only available in the template e.g.
+	// `{{.Tags.region}}`
+	Tags map[string]string
+}
+
+// identityTemplateData The data that is available to the name template
+type identityTemplateData struct {
+	Hostname    string
+	PodName     string
+	ServiceName string
+	Version     string
+	Tags        map[string]string
+}
+
+// Name Renders the name of the client using the template
+func (i Identity) Name() (string, error) {
+	nameTemplate := i.NameTemplate
+
+	if nameTemplate == "" {
+		nameTemplate = IdentityNameTemplateDefault
+	}
+
+	tmpl, err := template.New("identity").Option("missingkey=zero").Parse(nameTemplate)
+
+	if err != nil {
+		return "", err
+	}
+
+	hostname, err := os.Hostname()
+
+	if err != nil {
+		return "", err
+	}
+
+	data := identityTemplateData{
+		Hostname:    hostname,
+		PodName:     i.PodName,
+		ServiceName: i.ServiceName,
+		Version:     i.Version,
+		Tags:        i.Tags,
+	}
+
+	if data.PodName == "" {
+		data.PodName = os.Getenv(PodNameEnvVar)
+	}
+
+	var name strings.Builder
+
+	err = tmpl.Execute(&name, data)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(name.String()), nil
+}
@@ -0,0 +1,70 @@
+package connect
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIdentityName(t *testing.T) {
+	hostname, err := os.Hostname()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("with defaults", func(t *testing.T) {
+		name, err := Identity{}.Name()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != hostname {
+			t.Errorf("expected name to be the hostname %v, got %v", hostname, name)
+		}
+	})
+
+	t.Run("with a template", func(t *testing.T) {
+		i := Identity{
+			NameTemplate: "{{.ServiceName}}@{{.Version}} ({{.PodName}}) {{.Tags.region}}{{.Tags.missing}}",
+			ServiceName:  "aws-source",
+			Version:      "1.2.3",
+			PodName:      "aws-source-7d9f-abcde",
+			Tags: map[string]string{
+				"region": "eu-west-2",
+			},
+		}
+
+		name, err := i.Name()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != "aws-source@1.2.3 (aws-source-7d9f-abcde) eu-west-2" {
+			t.Errorf("unexpected name: %v", name)
+		}
+	})
+
+	t.Run("with the pod name from the environment", func(t *testing.T) {
+		t.Setenv(PodNameEnvVar, "from-env")
+
+		name, err := Identity{NameTemplate: "{{.PodName}}"}.Name()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name != "from-env" {
+			t.Errorf("expected pod name from environment, got %v", name)
+		}
+	})
+
+	t.Run("with an invalid template", func(t *testing.T) {
+		_, err := Identity{NameTemplate: "{{.Hostname"}.Name()
+
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package connect
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Introspector Is implemented by token clients that can describe the NATS JWT
+// that they are using. This allows the permissions and expiry of the current
+// token to be logged or reported in health checks
+type Introspector interface {
+	// Introspect Returns a summary of the claims in the current JWT, getting a
+	// JWT first if required
+	Introspect() (*TokenInfo, error)
+}
+
+// SubjectPermissions The subjects that a user is allowed and denied for
+// either publishing or subscribing
+type SubjectPermissions struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// TokenInfo A summary of the claims in a NATS user JWT
+type TokenInfo struct {
+	// The name of the user
+	Name string `json:"name"`
+	// The public key of the user that the token was issued for
+	Subject string `json:"subject"`
+	// The public key of the account that the user belongs to
+	Account string `json:"account"`
+	// The public key that signed the token. This will be either the account
+	// key or one of its signing keys
+	Issuer string `json:"issuer"`
+	// When the token expires. This is nil if the token doesn't expire
+	Expires *time.Time `json:"expires,omitempty"`
+	// Subjects the user can publish to
+	Publish SubjectPermissions `json:"publish"`
+	// Subjects the user can subscribe to
+	Subscribe SubjectPermissions `json:"subscribe"`
+
+	// The full decoded claims
+	Claims *jwt.UserClaims `json:"-"`
+}
+
+// IntrospectJWT Decodes a NATS user JWT and summarises its claims. Note that
+// this doesn't verify the token
+func IntrospectJWT(token string) (*TokenInfo, error) {
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &TokenInfo{
+		Name:    claims.Name,
+		Subject: claims.Subject,
+		Account: claims.IssuerAccount,
+		Issuer:  claims.Issuer,
+		Publish: SubjectPermissions{
+			Allow: claims.Pub.Allow,
+			Deny:  claims.Pub.Deny,
+		},
+		Subscribe: SubjectPermissions{
+			Allow: claims.Sub.Allow,
+			Deny:  claims.Sub.Deny,
+		},
+		Claims: claims,
+	}
+
+	// If the token was signed directly by the account then the issuer is the
+	// account
+	if info.Account == "" {
+		info.Account = claims.Issuer
+	}
+
+	if claims.Expires != 0 {
+		expires := time.Unix(claims.Expires, 0)
+		info.Expires = &expires
+	}
+
+	return info, nil
+}
+
+func (b *BasicTokenClient) Introspect() (*TokenInfo, error) {
+	token, err := b.GetJWT()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return IntrospectJWT(token)
+}
+
+func (o *OAuthTokenClient) Introspect() (*TokenInfo, error) {
+	token, err := o.GetJWT()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return IntrospectJWT(token)
+}
+
+func (c *CredsFileTokenClient) Introspect() (*TokenInfo, error) {
+	token, err := c.GetJWT()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return IntrospectJWT(token)
+}
+
+// Introspect Describes the JWT of the token client that is currently in use,
+// as long as it implements `Introspector`
+func (c *ChainTokenClient) Introspect() (*TokenInfo, error) {
+	token, err := c.GetJWT()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.current == nil {
+		return nil, errors.New("no token client is in use")
+	}
+
+	if _, ok := c.current.Client.(Introspector); !ok {
+		return nil, fmt.Errorf("token client %v does not support introspection", c.current.Name)
+	}
+
+	return IntrospectJWT(token)
+}
@@ -0,0 +1,31 @@
+package connect_test
+
+import (
+	"testing"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestOAuthTokenClientIntrospect(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	c := api.NewTokenClient("")
+
+	var i connect.Introspector = c
+
+	info, err := i.Introspect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, _ := c.Signer.PublicKey()
+
+	if info.Subject != pubKey {
+		t.Errorf("expected subject %v, got %v", pubKey, info.Subject)
+	}
+
+	if info.Expires == nil {
+		t.Error("expected an expiry")
+	}
+}
@@ -0,0 +1,158 @@
+package connect
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+func TestIntrospect(t *testing.T) {
+	accountKeys, err := nkeys.CreateAccount()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountPubKey, _ := accountKeys.PublicKey()
+
+	userKeys, err := nkeys.CreateUser()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userPubKey, _ := userKeys.PublicKey()
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	claims := jwt.NewUserClaims(userPubKey)
+	claims.Name = "test-user"
+	claims.Expires = expires.Unix()
+	claims.Pub.Allow.Add("request.>")
+	claims.Pub.Deny.Add("request.secret")
+	claims.Sub.Allow.Add("_INBOX.>", "return.>")
+
+	token, err := claims.Encode(accountKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(t *testing.T, info *TokenInfo) {
+		t.Helper()
+
+		if info.Name != "test-user" {
+			t.Errorf("expected name test-user, got %v", info.Name)
+		}
+
+		if info.Subject != userPubKey {
+			t.Errorf("expected subject %v, got %v", userPubKey, info.Subject)
+		}
+
+		if info.Account != accountPubKey || info.Issuer != accountPubKey {
+			t.Errorf("expected account and issuer %v, got %v and %v", accountPubKey, info.Account, info.Issuer)
+		}
+
+		if info.Expires == nil || !info.Expires.Equal(expires) {
+			t.Errorf("expected expiry %v, got %v", expires, info.Expires)
+		}
+
+		if len(info.Publish.Allow) != 1 || info.Publish.Allow[0] != "request.>" {
+			t.Errorf("unexpected publish allow list %v", info.Publish.Allow)
+		}
+
+		if len(info.Publish.Deny) != 1 || info.Publish.Deny[0] != "request.secret" {
+			t.Errorf("unexpected publish deny list %v", info.Publish.Deny)
+		}
+
+		if len(info.Subscribe.Allow) != 2 {
+			t.Errorf("unexpected subscribe allow list %v", info.Subscribe.Allow)
+		}
+
+		if info.Claims == nil {
+			t.Error("expected full claims to be included")
+		}
+	}
+
+	t.Run("BasicTokenClient", func(t *testing.T) {
+		var i Introspector = NewBasicTokenClient(token, userKeys)
+
+		info, err := i.Introspect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		validate(t, info)
+	})
+
+	t.Run("CredsFileTokenClient", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "user.creds")
+		keys := writeTestCredsFile(t, path)
+		pubKey, _ := keys.PublicKey()
+
+		var i Introspector = NewCredsFileTokenClient(path)
+
+		info, err := i.Introspect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Subject != pubKey {
+			t.Errorf("expected subject %v, got %v", pubKey, info.Subject)
+		}
+	})
+
+	t.Run("with a signing key", func(t *testing.T) {
+		signingKeys, err := nkeys.CreateAccount()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signingPubKey, _ := signingKeys.PublicKey()
+
+		claims := jwt.NewUserClaims(userPubKey)
+		claims.IssuerAccount = accountPubKey
+
+		token, err := claims.Encode(signingKeys)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := IntrospectJWT(token)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Account != accountPubKey {
+			t.Errorf("expected account %v, got %v", accountPubKey, info.Account)
+		}
+
+		if info.Issuer != signingPubKey {
+			t.Errorf("expected issuer %v, got %v", signingPubKey, info.Issuer)
+		}
+
+		if info.Expires != nil {
+			t.Errorf("expected no expiry, got %v", info.Expires)
+		}
+
+		encoded, err := json.Marshal(info)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(encoded), "expires") {
+			t.Errorf("expected no expiry in the JSON, got %v", string(encoded))
+		}
+	})
+}
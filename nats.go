@@ -1,7 +1,11 @@
 package connect
 
 import (
+	"context"
+	"errors"
+	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/overmindtech/sdp-go"
@@ -85,9 +89,41 @@ var ErrorHandlerDefault = func(c *nats.Conn, s *nats.Subscription, e error) {
 	log.WithFields(fields).Error("NATS error")
 }
 
+// IsAuthError Returns true if the error means that the NATS server has
+// rejected the credentials that the connection is using, for example because
+// the JWT has expired or been revoked. Reconnecting with the same JWT will fail
+func IsAuthError(err error) bool {
+	return errors.Is(err, nats.ErrAuthExpired) ||
+		errors.Is(err, nats.ErrAuthRevoked) ||
+		errors.Is(err, nats.ErrAuthorization) ||
+		errors.Is(err, nats.ErrAccountAuthExpired)
+}
+
+// invalidateOnAuthError Invalidates the JWT of the token client if the error
+// is an auth error, so that nats.go reconnects with a fresh JWT. nats.go
+// gives up on a server if it rejects the same credentials twice in a row
+func invalidateOnAuthError(client TokenClient, err error) {
+	if !IsAuthError(err) {
+		return
+	}
+
+	i, ok := client.(Invalidator)
+
+	if !ok {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Info("NATS rejected credentials, invalidating JWT")
+
+	i.InvalidateJWT()
+}
+
 type NATSOptions struct {
 	Servers              []string            // List of server to connect to
 	ConnectionName       string              // The client name
+	Identity             *Identity           // Used to generate the client name if ConnectionName is not set
 	MaxReconnects        int                 // The maximum number of reconnect attempts
 	ConnectionTimeout    time.Duration       // The timeout for Dial on a connection
 	ReconnectWait        time.Duration       // Wait time between reconnect attempts
@@ -101,16 +137,36 @@ type NATSOptions struct {
 	AdditionalOptions    []nats.Option       // Addition options to pass to the connection
 	NumRetries           int                 // How many times to retry connecting initially, use -1 to retry indefinitely
 	RetryDelay           time.Duration       // Delay between connection attempts
+	PublishSubjects      []string            // Subjects the client needs to publish to, checked against the JWT before connecting
+	SubscribeSubjects    []string            // Subjects the client needs to subscribe to, checked against the JWT before connecting
+	CloseTokenClient     bool                // Close the TokenClient when the connection is closed. Only set this if nothing else uses the TokenClient
 }
 
 // ToNatsOptions Converts the struct to connection string and a set of NATS
 // options
 func (o NATSOptions) ToNatsOptions() (string, []nats.Option) {
+	return o.toNatsOptions(context.Background)
+}
+
+// toNatsOptions Converts the struct to connection string and a set of NATS
+// options. If the token client is a `ContextTokenClient`, `tokenContext` is
+// called to get the context for each token request
+func (o NATSOptions) toNatsOptions(tokenContext func() context.Context) (string, []nats.Option) {
 	serverString := strings.Join(o.Servers, ",")
 	options := make([]nats.Option, 0)
 
 	if o.ConnectionName != "" {
 		options = append(options, nats.Name(o.ConnectionName))
+	} else if o.Identity != nil {
+		name, err := o.Identity.Name()
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Could not generate NATS connection name from identity")
+		} else {
+			options = append(options, nats.Name(name))
+		}
 	}
 
 	if o.MaxReconnects != 0 {
@@ -137,14 +193,44 @@ func (o NATSOptions) ToNatsOptions() (string, []nats.Option) {
 		options = append(options, nats.ReconnectJitter(ReconnectJitterDefault, ReconnectJitterDefault))
 	}
 
-	if o.TokenClient != nil {
+	if c, ok := o.TokenClient.(ContextTokenClient); ok {
+		options = append(options, nats.UserJWT(
+			func() (string, error) {
+				return c.GetJWTContext(tokenContext())
+			},
+			func(nonce []byte) ([]byte, error) {
+				return c.SignContext(tokenContext(), nonce)
+			},
+		))
+	} else if o.TokenClient != nil {
 		options = append(options, nats.UserJWT(o.TokenClient.GetJWT, o.TokenClient.Sign))
 	}
 
+	if o.TokenClient != nil {
+
+		// Since an auth error invalidates the JWT, the next attempt will use
+		// different credentials so there is no need to give up on the server
+		if _, ok := o.TokenClient.(Invalidator); ok {
+			options = append(options, nats.IgnoreAuthErrorAbort())
+		}
+	}
+
+	disconnectErrHandler := DisconnectErrHandlerDefault
+
 	if o.DisconnectErrHandler != nil {
-		options = append(options, nats.DisconnectErrHandler(o.DisconnectErrHandler))
+		disconnectErrHandler = o.DisconnectErrHandler
+	}
+
+	if o.TokenClient != nil {
+		// When the JWT expires or is revoked the server sends an error and
+		// closes the connection. Invalidate the JWT so that the reconnect
+		// uses fresh credentials
+		options = append(options, nats.DisconnectErrHandler(func(c *nats.Conn, e error) {
+			invalidateOnAuthError(o.TokenClient, e)
+			disconnectErrHandler(c, e)
+		}))
 	} else {
-		options = append(options, nats.DisconnectErrHandler(DisconnectErrHandlerDefault))
+		options = append(options, nats.DisconnectErrHandler(disconnectErrHandler))
 	}
 
 	if o.ReconnectHandler != nil {
@@ -153,10 +239,28 @@ func (o NATSOptions) ToNatsOptions() (string, []nats.Option) {
 		options = append(options, nats.ReconnectHandler(ReconnectHandlerDefault))
 	}
 
+	closedHandler := ClosedHandlerDefault
+
 	if o.ClosedHandler != nil {
-		options = append(options, nats.ClosedHandler(o.ClosedHandler))
+		closedHandler = o.ClosedHandler
+	}
+
+	if closer, ok := o.TokenClient.(io.Closer); ok && o.CloseTokenClient {
+		// Once the connection is closed for good the credentials aren't
+		// needed any more, so wipe them
+		options = append(options, nats.ClosedHandler(func(c *nats.Conn) {
+			closedHandler(c)
+
+			err := closer.Close()
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("Could not close NATS token client")
+			}
+		}))
 	} else {
-		options = append(options, nats.ClosedHandler(ClosedHandlerDefault))
+		options = append(options, nats.ClosedHandler(closedHandler))
 	}
 
 	if o.LameDuckModeHandler != nil {
@@ -165,10 +269,19 @@ func (o NATSOptions) ToNatsOptions() (string, []nats.Option) {
 		options = append(options, nats.LameDuckModeHandler(LameDuckModeHandlerDefault))
 	}
 
+	errorHandler := ErrorHandlerDefault
+
 	if o.ErrorHandler != nil {
-		options = append(options, nats.ErrorHandler(o.ErrorHandler))
+		errorHandler = o.ErrorHandler
+	}
+
+	if o.TokenClient != nil {
+		options = append(options, nats.ErrorHandler(func(c *nats.Conn, s *nats.Subscription, e error) {
+			invalidateOnAuthError(o.TokenClient, e)
+			errorHandler(c, s, e)
+		}))
 	} else {
-		options = append(options, nats.ErrorHandler(ErrorHandlerDefault))
+		options = append(options, nats.ErrorHandler(errorHandler))
 	}
 
 	options = append(options, o.AdditionalOptions...)
@@ -177,9 +290,30 @@ func (o NATSOptions) ToNatsOptions() (string, []nats.Option) {
 }
 
 // Connect Connects to NATS using the supplied options, including retrying if
-// unavailable
+// unavailable. If `PublishSubjects` or `SubscribeSubjects` are set, the JWT is
+// checked first and a `PermissionsError` is returned if it doesn't permit them
 func (o NATSOptions) Connect() (sdp.EncodedConnection, error) {
-	servers, opts := o.ToNatsOptions()
+	return o.ConnectContext(context.Background())
+}
+
+// ConnectContext Connects to NATS in the same way as `Connect`. If the token
+// client is a `ContextTokenClient`, tokens requested while connecting use
+// `ctx` so that they are part of the caller's trace. Tokens requested when
+// reconnecting later use a background context, since `ctx` may have been
+// cancelled by then. Cancelling `ctx` stops any further retries
+func (o NATSOptions) ConnectContext(ctx context.Context) (sdp.EncodedConnection, error) {
+	// Token requests use the caller's context until we have connected
+	var connected atomic.Bool
+
+	tokenContext := func() context.Context {
+		if connected.Load() {
+			return context.Background()
+		}
+
+		return ctx
+	}
+
+	servers, opts := o.toNatsOptions(tokenContext)
 
 	var triesLeft int
 
@@ -192,15 +326,39 @@ func (o NATSOptions) Connect() (sdp.EncodedConnection, error) {
 	var nc *nats.Conn
 	var err error
 
+	// The permissions are checked once a JWT can be got. Failing to get one is
+	// retried in the same way as failing to connect
+	checkPermissions := o.TokenClient != nil && (len(o.PublishSubjects) > 0 || len(o.SubscribeSubjects) > 0)
+
 	for triesLeft != 0 {
-		log.WithFields(log.Fields{
-			"servers": servers,
-		}).Info("NATS connecting")
+		err = nil
+
+		if checkPermissions {
+			err = CheckPermissionsContext(ctx, o.TokenClient, o.PublishSubjects, o.SubscribeSubjects)
+
+			var permErr PermissionsError
+
+			if errors.As(err, &permErr) {
+				log.WithFields(log.Fields{
+					"error": err.Error(),
+				}).Error("NATS permission check failed")
 
-		nc, err = nats.Connect(
-			servers,
-			opts...,
-		)
+				return &sdp.EncodedConnectionImpl{}, err
+			}
+
+			checkPermissions = err != nil
+		}
+
+		if err == nil {
+			log.WithFields(log.Fields{
+				"servers": servers,
+			}).Info("NATS connecting")
+
+			nc, err = nats.Connect(
+				servers,
+				opts...,
+			)
+		}
 
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -208,7 +366,13 @@ func (o NATSOptions) Connect() (sdp.EncodedConnection, error) {
 			}).Error("Error connecting to NATS")
 
 			triesLeft--
-			time.Sleep(o.RetryDelay)
+
+			select {
+			case <-ctx.Done():
+				return &sdp.EncodedConnectionImpl{}, ctx.Err()
+			case <-time.After(o.RetryDelay):
+			}
+
 			continue
 		}
 
@@ -219,5 +383,7 @@ func (o NATSOptions) Connect() (sdp.EncodedConnection, error) {
 		return &sdp.EncodedConnectionImpl{}, MaxRetriesError{}
 	}
 
+	connected.Store(true)
+
 	return &sdp.EncodedConnectionImpl{Conn: nc}, nil
 }
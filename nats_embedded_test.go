@@ -0,0 +1,221 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+type contextKey struct{}
+
+func TestNATSConnectEmbedded(t *testing.T) {
+	s := connecttest.Start(t)
+
+	newOptions := func(t *testing.T) connect.NATSOptions {
+		t.Helper()
+
+		o, err := s.NATSOptions(connecttest.UserConfig{Name: t.Name()})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return o
+	}
+
+	t.Run("with a bad URL, but a good token", func(t *testing.T) {
+		tk, err := s.NewTokenClient(connecttest.UserConfig{Name: t.Name()})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		startToken, err := tk.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := connect.NATSOptions{
+			Servers:     []string{"nats://badname.dontresolve.com"},
+			TokenClient: tk,
+			NumRetries:  3,
+			RetryDelay:  100 * time.Millisecond,
+		}
+
+		_, err = o.Connect()
+
+		var retriesErr connect.MaxRetriesError
+
+		if !errors.As(err, &retriesErr) {
+			t.Fatalf("Unknown error type %T", err)
+		}
+
+		// Make sure we have only got one token, not three
+		currentToken, err := tk.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if currentToken != startToken || tk.Minted() != 1 {
+			t.Error("Tokens have changed")
+		}
+	})
+
+	t.Run("with a good URL", func(t *testing.T) {
+		o := newOptions(t)
+		o.NumRetries = 3
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		connect.ValidateNATSConnection(t, conn)
+	})
+
+	t.Run("with a good URL but no retries", func(t *testing.T) {
+		o := newOptions(t)
+		o.NumRetries = 0
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		connect.ValidateNATSConnection(t, conn)
+	})
+
+	t.Run("with a good URL and infinite retries", func(t *testing.T) {
+		o := newOptions(t)
+		o.NumRetries = -1
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		connect.ValidateNATSConnection(t, conn)
+	})
+
+	t.Run("with an OAuth token client", func(t *testing.T) {
+		api := connecttest.StartAPI(t, s)
+
+		o := newOptions(t)
+		o.TokenClient = api.NewTokenClient("")
+
+		conn, err := o.Connect()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		connect.ValidateNATSConnection(t, conn)
+
+		if len(api.Requests(connecttest.EndpointOAuth)) == 0 || len(api.Requests(connecttest.EndpointCreateToken)) == 0 {
+			t.Error("expected the token to be fetched using OAuth")
+		}
+	})
+
+	t.Run("uses the caller's context", func(t *testing.T) {
+		tc, err := s.NewMockTokenClient(connecttest.UserConfig{Name: t.Name()})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		o := connect.NATSOptions{
+			Servers:     []string{s.URL},
+			TokenClient: tc,
+		}
+
+		ctx := context.WithValue(context.Background(), contextKey{}, "caller")
+
+		conn, err := o.ConnectContext(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		contexts := tc.Contexts()
+
+		if len(contexts) == 0 {
+			t.Fatal("expected the token client to be called")
+		}
+
+		for _, c := range contexts {
+			if c.Value(contextKey{}) != "caller" {
+				t.Error("expected the caller's context to be used while connecting")
+			}
+		}
+	})
+}
+
+func TestNATSReconnectOnExpiry(t *testing.T) {
+	s := connecttest.Start(t)
+
+	// The mock never checks whether its JWT has expired, so it relies on
+	// being invalidated
+	tc, err := s.NewMockTokenClient(connecttest.UserConfig{Name: t.Name(), Expiry: 2 * time.Second})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reconnected := make(chan struct{}, 10)
+
+	o := connect.NATSOptions{
+		Servers:         []string{s.URL},
+		TokenClient:     tc,
+		ReconnectWait:   100 * time.Millisecond,
+		ReconnectJitter: time.Millisecond,
+		ReconnectHandler: func(c *nats.Conn) {
+			reconnected <- struct{}{}
+		},
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	// The server disconnects us when the JWT expires, after which we should
+	// reconnect with a new one
+	select {
+	case <-reconnected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting to reconnect")
+	}
+
+	if issued := tc.Issued(); issued < 2 {
+		t.Errorf("expected a new JWT to be used to reconnect, got %v JWTs", issued)
+	}
+
+	if !conn.Underlying().IsConnected() {
+		t.Error("expected to be connected")
+	}
+
+	connect.ValidateNATSConnection(t, conn)
+}
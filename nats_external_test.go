@@ -0,0 +1,62 @@
+package connect_test
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestToNatsOptionsInvalidation(t *testing.T) {
+	tc, err := connecttest.NewMockTokenClient(connecttest.UserConfig{Name: t.Name()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var errorHandlerUsed bool
+	var disconnectErrHandlerUsed bool
+
+	o := connect.NATSOptions{
+		TokenClient:          tc,
+		ErrorHandler:         func(c *nats.Conn, s *nats.Subscription, err error) { errorHandlerUsed = true },
+		DisconnectErrHandler: func(c *nats.Conn, err error) { disconnectErrHandlerUsed = true },
+	}
+
+	_, options := o.ToNatsOptions()
+
+	actualOptions, err := connect.OptionsToStruct(options)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !actualOptions.IgnoreAuthErrorAbort {
+		t.Error("expected auth errors not to abort reconnecting")
+	}
+
+	// Other errors leave the JWT alone
+	actualOptions.AsyncErrorCB(nil, nil, nats.ErrSlowConsumer)
+	actualOptions.DisconnectedErrCB(nil, nil)
+
+	if calls := tc.CallCount("InvalidateJWT"); calls != 0 {
+		t.Errorf("expected JWT not to be invalidated, got %v calls", calls)
+	}
+
+	if !errorHandlerUsed || !disconnectErrHandlerUsed {
+		t.Error("expected supplied handlers to be called")
+	}
+
+	actualOptions.AsyncErrorCB(nil, nil, nats.ErrAuthExpired)
+
+	if calls := tc.CallCount("InvalidateJWT"); calls != 1 {
+		t.Errorf("expected JWT to be invalidated, got %v calls", calls)
+	}
+
+	actualOptions.DisconnectedErrCB(nil, nats.ErrAuthRevoked)
+
+	if calls := tc.CallCount("InvalidateJWT"); calls != 2 {
+		t.Errorf("expected JWT to be invalidated again, got %v calls", calls)
+	}
+}
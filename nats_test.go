@@ -2,6 +2,9 @@ package connect
 
 import (
 	"context"
+	"errors"
+	"net"
+	"os"
 	"testing"
 	"time"
 
@@ -192,6 +195,51 @@ func TestToNatsOptions(t *testing.T) {
 	})
 }
 
+func TestToNatsOptionsIdentity(t *testing.T) {
+	identity := &Identity{
+		NameTemplate: "{{.ServiceName}}@{{.Version}}",
+		ServiceName:  "test-service",
+		Version:      "1.0.0",
+	}
+
+	t.Run("without a connection name", func(t *testing.T) {
+		o := NATSOptions{
+			Identity: identity,
+		}
+
+		_, options := o.ToNatsOptions()
+
+		actualOptions, err := optionsToStruct(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actualOptions.Name != "test-service@1.0.0" {
+			t.Errorf("expected connection name to come from the identity, got %v", actualOptions.Name)
+		}
+	})
+
+	t.Run("with a connection name", func(t *testing.T) {
+		o := NATSOptions{
+			ConnectionName: "explicit",
+			Identity:       identity,
+		}
+
+		_, options := o.ToNatsOptions()
+
+		actualOptions, err := optionsToStruct(options)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actualOptions.Name != "explicit" {
+			t.Errorf("expected explicit connection name, got %v", actualOptions.Name)
+		}
+	})
+}
+
 func TestNATSConnect(t *testing.T) {
 	t.Run("with a bad URL", func(t *testing.T) {
 		o := NATSOptions{
@@ -259,6 +307,8 @@ func TestNATSConnect(t *testing.T) {
 	})
 
 	t.Run("with a good URL", func(t *testing.T) {
+		skipWithoutTestNATS(t)
+
 		o := NATSOptions{
 			Servers: []string{
 				"nats://nats:4222",
@@ -278,6 +328,8 @@ func TestNATSConnect(t *testing.T) {
 	})
 
 	t.Run("with a good URL but no retries", func(t *testing.T) {
+		skipWithoutTestNATS(t)
+
 		o := NATSOptions{
 			Servers: []string{
 				"nats://nats:4222",
@@ -295,6 +347,8 @@ func TestNATSConnect(t *testing.T) {
 	})
 
 	t.Run("with a good URL and infinite retries", func(t *testing.T) {
+		skipWithoutTestNATS(t)
+
 		o := NATSOptions{
 			Servers: []string{
 				"nats://nats:4222",
@@ -314,6 +368,28 @@ func TestNATSConnect(t *testing.T) {
 	})
 }
 
+// skipWithoutTestNATS Skips the test unless one of the NATS servers from the
+// development environment is reachable. CI always starts them, so there it
+// fails instead
+func skipWithoutTestNATS(t *testing.T) {
+	t.Helper()
+
+	for _, address := range []string{"nats:4222", "localhost:4223"} {
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+
+		if err == nil {
+			conn.Close()
+			return
+		}
+	}
+
+	if os.Getenv("CI") != "" {
+		t.Fatal("none of the test NATS servers are reachable")
+	}
+
+	t.Skip("Skipping due to missing NATS server")
+}
+
 func TestTokenRefresh(t *testing.T) {
 	tk := GetTestOAuthTokenClient(t)
 
@@ -357,6 +433,20 @@ func TestTokenRefresh(t *testing.T) {
 	}
 }
 
+func TestIsAuthError(t *testing.T) {
+	for _, err := range []error{nats.ErrAuthExpired, nats.ErrAuthRevoked, nats.ErrAuthorization, nats.ErrAccountAuthExpired} {
+		if !IsAuthError(err) {
+			t.Errorf("expected %v to be an auth error", err)
+		}
+	}
+
+	for _, err := range []error{nil, nats.ErrConnectionClosed, errors.New("foo")} {
+		if IsAuthError(err) {
+			t.Errorf("expected %v not to be an auth error", err)
+		}
+	}
+}
+
 func ValidateNATSConnection(t *testing.T, ec sdp.EncodedConnection) {
 	t.Helper()
 	done := make(chan struct{})
@@ -0,0 +1,174 @@
+package connect
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PermissionDenial Describes why a subject isn't permitted
+type PermissionDenial struct {
+	Subject string `json:"subject"`
+	Reason  string `json:"reason"`
+}
+
+// PermissionsError Returned when the JWT doesn't permit the client to publish
+// or subscribe to subjects that it needs
+type PermissionsError struct {
+	Publish   []PermissionDenial
+	Subscribe []PermissionDenial
+}
+
+func (p PermissionsError) Error() string {
+	problems := make([]string, 0, len(p.Publish)+len(p.Subscribe))
+
+	for _, d := range p.Publish {
+		problems = append(problems, fmt.Sprintf("cannot publish to %v: %v", d.Subject, d.Reason))
+	}
+
+	for _, d := range p.Subscribe {
+		problems = append(problems, fmt.Sprintf("cannot subscribe to %v: %v", d.Subject, d.Reason))
+	}
+
+	return fmt.Sprintf("insufficient NATS permissions: %v", strings.Join(problems, "; "))
+}
+
+// CheckPermissions Checks that the JWT from the supplied token client allows
+// publishing to each of `publishSubjects` and subscribing to each of
+// `subscribeSubjects`. Subjects may contain wildcards, in which case every
+// subject that they match must be allowed. Returns a `PermissionsError`
+// describing every subject that isn't permitted
+func CheckPermissions(client TokenClient, publishSubjects []string, subscribeSubjects []string) error {
+	return CheckPermissionsContext(context.Background(), client, publishSubjects, subscribeSubjects)
+}
+
+// CheckPermissionsContext Like `CheckPermissions`, but the JWT is requested
+// using `ctx`, so that cancelling it stops any retries or interactive logins
+func CheckPermissionsContext(ctx context.Context, client TokenClient, publishSubjects []string, subscribeSubjects []string) error {
+	token, err := AsContextTokenClient(client).GetJWTContext(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := IntrospectJWT(token)
+
+	if err != nil {
+		return err
+	}
+
+	var permErr PermissionsError
+
+	for _, subject := range publishSubjects {
+		if reason, ok := checkSubject(subject, info.Publish); !ok {
+			permErr.Publish = append(permErr.Publish, PermissionDenial{
+				Subject: subject,
+				Reason:  reason,
+			})
+		}
+	}
+
+	for _, subject := range subscribeSubjects {
+		if reason, ok := checkSubject(subject, info.Subscribe); !ok {
+			permErr.Subscribe = append(permErr.Subscribe, PermissionDenial{
+				Subject: subject,
+				Reason:  reason,
+			})
+		}
+	}
+
+	if len(permErr.Publish) > 0 || len(permErr.Subscribe) > 0 {
+		return permErr
+	}
+
+	return nil
+}
+
+// checkSubject Checks a subject against a set of permissions in the same way
+// as the NATS server. If there is an allow list the subject must be covered by
+// one of its entries, and it must not match anything that a deny entry
+// matches, since the server would deny those subjects. Returns the reason if
+// the subject isn't permitted
+func checkSubject(subject string, permissions SubjectPermissions) (string, bool) {
+	if len(permissions.Allow) > 0 {
+		allowed := false
+
+		for _, pattern := range permissions.Allow {
+			if subjectCoveredBy(subject, pattern) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Sprintf("not covered by allow list [%v]", strings.Join(permissions.Allow, ", ")), false
+		}
+	}
+
+	for _, pattern := range permissions.Deny {
+		if subjectsOverlap(subject, pattern) {
+			return fmt.Sprintf("denied by %v", pattern), false
+		}
+	}
+
+	return "", true
+}
+
+// subjectCoveredBy Returns true if every subject matched by `subject` is also
+// matched by `pattern`. Both may contain the `*` and `>` wildcards
+func subjectCoveredBy(subject string, pattern string) bool {
+	subjectTokens := strings.Split(subject, ".")
+	patternTokens := strings.Split(pattern, ".")
+
+	for i, patternToken := range patternTokens {
+		if patternToken == ">" {
+			// Matches one or more remaining tokens, whatever they are
+			return i < len(subjectTokens)
+		}
+
+		if i >= len(subjectTokens) {
+			return false
+		}
+
+		subjectToken := subjectTokens[i]
+
+		switch {
+		case patternToken == "*":
+			// Matches any single token, but not the many tokens of `>`
+			if subjectToken == ">" {
+				return false
+			}
+		case subjectToken == "*" || subjectToken == ">":
+			// A literal can't cover a wildcard
+			return false
+		case patternToken != subjectToken:
+			return false
+		}
+	}
+
+	return len(subjectTokens) == len(patternTokens)
+}
+
+// subjectsOverlap Returns true if there is at least one subject that is
+// matched by both `a` and `b`. Both may contain the `*` and `>` wildcards
+func subjectsOverlap(a string, b string) bool {
+	aTokens := strings.Split(a, ".")
+	bTokens := strings.Split(b, ".")
+
+	for i := 0; i < len(aTokens) && i < len(bTokens); i++ {
+		aToken := aTokens[i]
+		bToken := bTokens[i]
+
+		switch {
+		case aToken == ">" || bToken == ">":
+			// Both have at least one token here, which is all `>` needs
+			return true
+		case aToken == "*" || bToken == "*":
+			continue
+		case aToken != bToken:
+			return false
+		}
+	}
+
+	return len(aTokens) == len(bTokens)
+}
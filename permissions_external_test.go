@@ -0,0 +1,181 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+// newPermissionsClient Creates a token client whose JWT has the permissions in
+// `config`
+func newPermissionsClient(t *testing.T, config connecttest.UserConfig) *connecttest.MockTokenClient {
+	t.Helper()
+
+	m, err := connecttest.NewMockTokenClient(config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestCheckPermissions(t *testing.T) {
+	client := newPermissionsClient(t, connecttest.UserConfig{
+		Publish:     []string{"request.scope.>", "cancel.>"},
+		DenyPublish: []string{"request.scope.secret.>"},
+		Subscribe:   []string{"_INBOX.>", "return.>"},
+	})
+
+	t.Run("with sufficient permissions", func(t *testing.T) {
+		err := connect.CheckPermissions(client, []string{"request.scope.foo.bar", "cancel.*"}, []string{"_INBOX.*", "return.>"})
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("with insufficient permissions", func(t *testing.T) {
+		err := connect.CheckPermissions(client, []string{"request.scope.secret.things", "request.all"}, []string{"return.>", ">"})
+
+		var permErr connect.PermissionsError
+
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected a PermissionsError, got %v", err)
+		}
+
+		if len(permErr.Publish) != 2 {
+			t.Fatalf("expected 2 publish denials, got %v", permErr.Publish)
+		}
+
+		if permErr.Publish[0].Subject != "request.scope.secret.things" || !strings.Contains(permErr.Publish[0].Reason, "request.scope.secret.>") {
+			t.Errorf("unexpected denial: %v", permErr.Publish[0])
+		}
+
+		if permErr.Publish[1].Subject != "request.all" || !strings.Contains(permErr.Publish[1].Reason, "allow list") {
+			t.Errorf("unexpected denial: %v", permErr.Publish[1])
+		}
+
+		if len(permErr.Subscribe) != 1 || permErr.Subscribe[0].Subject != ">" {
+			t.Errorf("unexpected subscribe denials: %v", permErr.Subscribe)
+		}
+	})
+
+	t.Run("with wildcards that overlap a deny entry", func(t *testing.T) {
+		err := connect.CheckPermissions(client, []string{"request.scope.>"}, nil)
+
+		var permErr connect.PermissionsError
+
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected a PermissionsError, got %v", err)
+		}
+
+		if len(permErr.Publish) != 1 || !strings.Contains(permErr.Publish[0].Reason, "request.scope.secret.>") {
+			t.Errorf("expected request.scope.> to be denied by request.scope.secret.>, got %v", permErr.Publish)
+		}
+
+		denied := newPermissionsClient(t, connecttest.UserConfig{DenySubscribe: []string{"secret.x"}})
+
+		for _, subject := range []string{">", "secret.*"} {
+			err = connect.CheckPermissions(denied, nil, []string{subject})
+
+			if !errors.As(err, &permErr) || len(permErr.Subscribe) != 1 {
+				t.Errorf("expected subscribing to %v to be denied by secret.x, got %v", subject, err)
+			}
+		}
+	})
+
+	t.Run("with no allow list", func(t *testing.T) {
+		open := newPermissionsClient(t, connecttest.UserConfig{DenySubscribe: []string{"secret"}})
+
+		err := connect.CheckPermissions(open, []string{">"}, []string{"foo.>", "secret"})
+
+		var permErr connect.PermissionsError
+
+		if !errors.As(err, &permErr) {
+			t.Fatalf("expected a PermissionsError, got %v", err)
+		}
+
+		if len(permErr.Publish) != 0 || len(permErr.Subscribe) != 1 {
+			t.Errorf("expected only the denied subject to fail, got %v", permErr)
+		}
+	})
+}
+
+func TestNATSConnectPermissions(t *testing.T) {
+	t.Run("with insufficient permissions", func(t *testing.T) {
+		o := connect.NATSOptions{
+			Servers:         []string{"nats://badname.dontresolve.com"},
+			TokenClient:     newPermissionsClient(t, connecttest.UserConfig{Publish: []string{"foo"}}),
+			NumRetries:      -1,
+			PublishSubjects: []string{"bar"},
+		}
+
+		// This would retry forever if the permissions weren't checked first
+		_, err := o.Connect()
+
+		var permErr connect.PermissionsError
+
+		if !errors.As(err, &permErr) {
+			t.Errorf("expected a PermissionsError, got %v", err)
+		}
+	})
+
+	t.Run("when getting a JWT fails temporarily", func(t *testing.T) {
+		flaky := newPermissionsClient(t, connecttest.UserConfig{Publish: []string{"foo"}})
+		flaky.FailGetJWT(connect.ErrServerUnavailable, connect.ErrServerUnavailable)
+
+		o := connect.NATSOptions{
+			Servers:         []string{"nats://badname.dontresolve.com"},
+			TokenClient:     flaky,
+			NumRetries:      3,
+			RetryDelay:      10 * time.Millisecond,
+			PublishSubjects: []string{"foo"},
+		}
+
+		// The token failures are retried, so this only fails because the
+		// server can't be reached
+		_, err := o.Connect()
+
+		if !errors.As(err, &connect.MaxRetriesError{}) {
+			t.Errorf("expected a MaxRetriesError, got %v", err)
+		}
+
+		if calls := flaky.CallCount("GetJWT"); calls <= 2 {
+			t.Errorf("expected the permission check to be retried, GetJWT was called %v times", calls)
+		}
+	})
+}
+
+func TestNATSConnectContextPermissions(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	api.Script(connecttest.EndpointCreateToken, connecttest.Failure{Latency: 2 * time.Second})
+
+	o := connect.NATSOptions{
+		Servers:         []string{"nats://badname.dontresolve.com"},
+		TokenClient:     api.NewTokenClient(""),
+		NumRetries:      -1,
+		PublishSubjects: []string{"foo"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	// Getting the JWT for the permission check is cancelled with the context
+	_, err := o.ConnectContext(ctx)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the permission check to stop when the context expired, took %v", elapsed)
+	}
+}
@@ -0,0 +1,62 @@
+package connect
+
+import "testing"
+
+func TestSubjectCoveredBy(t *testing.T) {
+	tests := []struct {
+		Subject string
+		Pattern string
+		Covered bool
+	}{
+		{"foo.bar", "foo.bar", true},
+		{"foo.bar", "foo.baz", false},
+		{"foo.bar", "foo.*", true},
+		{"foo.bar.baz", "foo.*", false},
+		{"foo.bar.baz", "foo.>", true},
+		{"foo", "foo.>", false},
+		{"foo.*", "foo.*", true},
+		{"foo.*", "foo.>", true},
+		{"foo.>", "foo.*", false},
+		{"foo.>", "foo.>", true},
+		{"foo.*", "foo.bar", false},
+		{"foo.>", ">", true},
+		{"foo.bar", "*.bar", true},
+		{"foo.bar", "*", false},
+	}
+
+	for _, test := range tests {
+		if covered := subjectCoveredBy(test.Subject, test.Pattern); covered != test.Covered {
+			t.Errorf("expected subjectCoveredBy(%q, %q) to be %v, got %v", test.Subject, test.Pattern, test.Covered, covered)
+		}
+	}
+}
+
+func TestSubjectsOverlap(t *testing.T) {
+	tests := []struct {
+		A       string
+		B       string
+		Overlap bool
+	}{
+		{"foo.bar", "foo.bar", true},
+		{"foo.bar", "foo.baz", false},
+		{"foo.*", "foo.bar", true},
+		{"foo.*", "foo.bar.baz", false},
+		{"foo.>", "foo.bar.baz", true},
+		{"foo.>", "foo", false},
+		{">", "secret.x", true},
+		{"secret.*", "secret.x", true},
+		{"request.scope.>", "request.scope.secret.>", true},
+		{"*.bar", "foo.*", true},
+		{"foo.*.baz", "foo.bar.qux", false},
+	}
+
+	for _, test := range tests {
+		if overlap := subjectsOverlap(test.A, test.B); overlap != test.Overlap {
+			t.Errorf("expected subjectsOverlap(%q, %q) to be %v, got %v", test.A, test.B, test.Overlap, overlap)
+		}
+
+		if overlap := subjectsOverlap(test.B, test.A); overlap != test.Overlap {
+			t.Errorf("expected subjectsOverlap(%q, %q) to be %v, got %v", test.B, test.A, test.Overlap, overlap)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package connect
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+// AuthCodeTimeoutDefault How long to wait for the user to log in using the
+// browser before giving up
+const AuthCodeTimeoutDefault = 5 * time.Minute
+
+// BrowserOpener Opens a URL in the user's browser
+type BrowserOpener func(url string) error
+
+// OpenBrowser Opens a URL in the user's default browser using the
+// platform-specific launcher
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	return cmd.Start()
+}
+
+// AuthCodeConfig Authenticates to Overmind using the OAuth Authorization Code
+// Flow with PKCE. This is intended for desktop tooling where a browser is
+// available on the same machine
+// https://auth0.com/docs/get-started/authentication-and-authorization-flow/authorization-code-flow-with-proof-key-for-code-exchange-pkce
+type AuthCodeConfig struct {
+	// The ClientID of the application that the user will be logging in to
+	ClientID string
+	// The authorization endpoint e.g. https://auth.overmind.tech/authorize
+	AuthURL string
+	// The token endpoint e.g. https://auth.overmind.tech/oauth/token
+	TokenURL string
+	// Scopes to request. Include `offline_access` if the access token should
+	// be refreshed without prompting the user again
+	Scopes []string
+	// The audience to request a token for. Defaults to `DefaultAudience`
+	Audience string
+	// The account to request a NATS token for. This has the same meaning as
+	// `ClientCredentialsConfig.Account`
+	Account string
+	// The loopback address to listen on for the redirect. Defaults to
+	// `127.0.0.1:0` which picks a random port. Set this if the authorization
+	// server requires the callback URL to be registered in advance
+	ListenAddress string
+	// Opens the authorization URL. Defaults to `OpenBrowser`
+	OpenBrowser BrowserOpener
+	// Where the authorization URL will be printed if the browser can't be
+	// opened. Defaults to os.Stderr
+	Output io.Writer
+	// How long to wait for the user to log in. Defaults to
+	// `AuthCodeTimeoutDefault`
+	Timeout time.Duration
+	// The token revocation endpoint (RFC 7009). If set, the user's token is
+	// revoked when the client is closed
+	RevocationURL string
+}
+
+// AuthCodeTokenClient Gets a NATS token by first authenticating the user using
+// the OAuth Authorization Code Flow with PKCE, then using that token to
+// retrieve a NATS token in the same way as `OAuthTokenClient`
+type AuthCodeTokenClient struct {
+	*OAuthTokenClient
+}
+
+// NewAuthCodeTokenClient Generates a token client that authenticates the user
+// interactively by opening the authorization URL in their browser and
+// receiving the redirect on a loopback HTTP listener. `overmindAPIURL` is the
+// root URL of the NATS token exchange API e.g. https://api.server.test/v1
+func NewAuthCodeTokenClient(overmindAPIURL string, flowConfig AuthCodeConfig) *AuthCodeTokenClient {
+	a := &authCodeFlow{
+		config: &oauth2.Config{
+			ClientID: flowConfig.ClientID,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  flowConfig.AuthURL,
+				TokenURL: flowConfig.TokenURL,
+			},
+			Scopes: flowConfig.Scopes,
+		},
+		audience:      flowConfig.Audience,
+		listenAddress: flowConfig.ListenAddress,
+		openBrowser:   flowConfig.OpenBrowser,
+		output:        flowConfig.Output,
+		timeout:       flowConfig.Timeout,
+	}
+
+	if a.audience == "" {
+		a.audience = DefaultAudience
+	}
+
+	if a.listenAddress == "" {
+		a.listenAddress = "127.0.0.1:0"
+	}
+
+	if a.openBrowser == nil {
+		a.openBrowser = OpenBrowser
+	}
+
+	if a.output == nil {
+		a.output = os.Stderr
+	}
+
+	if a.timeout == 0 {
+		a.timeout = AuthCodeTimeoutDefault
+	}
+
+	ts := newInteractiveTokenSource(a.config, a.authenticate, flowConfig.RevocationURL)
+
+	return &AuthCodeTokenClient{
+		OAuthTokenClient: NewOAuthTokenClientWithTokenSource(overmindAPIURL, flowConfig.Account, ts),
+	}
+}
+
+// authCodeFlow Holds the config required to run the authorization code flow
+type authCodeFlow struct {
+	config        *oauth2.Config
+	audience      string
+	listenAddress string
+	openBrowser   BrowserOpener
+	output        io.Writer
+	timeout       time.Duration
+}
+
+// authCodeResult The outcome of the redirect back to the loopback listener
+type authCodeResult struct {
+	code string
+	err  error
+}
+
+// authenticate Runs the authorization code flow. This starts a loopback
+// listener, opens the authorization URL and waits for the authorization server
+// to redirect back with a code, which is then exchanged for a token
+func (a *authCodeFlow) authenticate(ctx context.Context) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", a.listenAddress)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not start listener for OAuth redirect: %w", err)
+	}
+
+	// Copy the config so that the redirect URL matches this listener
+	config := *a.config
+	config.RedirectURL = fmt.Sprintf("http://%v/callback", listener.Addr().String())
+
+	state, err := randomState()
+
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	results := make(chan authCodeResult, 1)
+
+	server := &http.Server{
+		Handler:           a.callbackHandler(state, results),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("OAuth redirect listener failed")
+		}
+	}()
+
+	defer server.Close()
+
+	authURL := config.AuthCodeURL(
+		state,
+		oauth2.S256ChallengeOption(verifier),
+		oauth2.SetAuthURLParam("audience", a.audience),
+	)
+
+	err = a.openBrowser(authURL)
+
+	if err != nil {
+		fmt.Fprintf(a.output, "Could not open browser (%v). To authenticate, visit: %v\n", err, authURL)
+	}
+
+	var result authCodeResult
+
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for OAuth redirect: %w", ctx.Err())
+	}
+
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	token, err := config.Exchange(ctx, result.code, oauth2.VerifierOption(verifier))
+
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code failed: %w", err)
+	}
+
+	return token, nil
+}
+
+// callbackHandler Handles the redirect from the authorization server, sending
+// the code (or error) to `results`. Requests with the wrong state didn't come
+// from our authorization request, so they are rejected without giving up on
+// the real redirect
+func (a *authCodeFlow) callbackHandler(state string, results chan<- authCodeResult) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if query.Get("state") != state {
+			http.Error(w, "OAuth redirect contained an invalid state", http.StatusBadRequest)
+			return
+		}
+
+		var result authCodeResult
+
+		switch {
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("authorization failed: %v: %v", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			result.err = errors.New("OAuth redirect did not contain an authorization code")
+		default:
+			result.code = query.Get("code")
+		}
+
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authentication complete, you can close this window.")
+		}
+
+		// Only the first redirect counts, anything after that is ignored
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	return mux
+}
+
+// randomState Generates a random value for the OAuth `state` parameter
+func randomState() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
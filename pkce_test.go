@@ -0,0 +1,162 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestAuthCodeTokenClient(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	var opened int
+
+	c := connect.NewAuthCodeTokenClient(api.ExchangeURL, connect.AuthCodeConfig{
+		ClientID: connecttest.ClientID,
+		AuthURL:  api.AuthURL,
+		TokenURL: api.OAuthURL,
+		OpenBrowser: func(authURL string) error {
+			opened++
+
+			// Follow the redirects like a browser would
+			res, err := http.Get(authURL)
+
+			if err != nil {
+				return err
+			}
+
+			return res.Body.Close()
+		},
+		Timeout: 10 * time.Second,
+	})
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opened != 1 {
+		t.Errorf("expected browser to be opened once, got %v", opened)
+	}
+
+	requests := api.Requests(connecttest.EndpointAuthorize)
+
+	if len(requests) != 1 || requests[0].Form.Get("audience") != connect.DefaultAudience {
+		t.Errorf("expected the user to log in for the default audience, got %v", requests)
+	}
+}
+
+func TestAuthCodeTokenClientInvalidState(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	var forged int
+
+	c := connect.NewAuthCodeTokenClient(api.ExchangeURL, connect.AuthCodeConfig{
+		ClientID: connecttest.ClientID,
+		AuthURL:  api.AuthURL,
+		TokenURL: api.OAuthURL,
+		OpenBrowser: func(authURL string) error {
+			u, err := url.Parse(authURL)
+
+			if err != nil {
+				return err
+			}
+
+			// Something else hits the callback before the real redirect
+			res, err := http.Get(u.Query().Get("redirect_uri") + "?state=forged&code=forged")
+
+			if err != nil {
+				return err
+			}
+
+			forged = res.StatusCode
+			res.Body.Close()
+
+			res, err = http.Get(authURL)
+
+			if err != nil {
+				return err
+			}
+
+			return res.Body.Close()
+		},
+		Timeout: 10 * time.Second,
+	})
+
+	_, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if forged != http.StatusBadRequest {
+		t.Errorf("expected the forged redirect to get a 400, got %v", forged)
+	}
+}
+
+func TestAuthCodeTokenClientCancelled(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	c := connect.NewAuthCodeTokenClient(api.ExchangeURL, connect.AuthCodeConfig{
+		ClientID: connecttest.ClientID,
+		AuthURL:  api.AuthURL,
+		TokenURL: api.OAuthURL,
+		OpenBrowser: func(authURL string) error {
+			// The user never logs in
+			return nil
+		},
+		Output:  io.Discard,
+		Timeout: time.Minute,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := c.GetJWTContext(ctx)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the login to stop when the context expired, took %v", elapsed)
+	}
+}
+
+func TestAuthCodeTokenClientTimeout(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	c := connect.NewAuthCodeTokenClient(api.ExchangeURL, connect.AuthCodeConfig{
+		ClientID: connecttest.ClientID,
+		AuthURL:  api.AuthURL,
+		TokenURL: api.OAuthURL,
+		OpenBrowser: func(authURL string) error {
+			// The user never logs in
+			return errors.New("no browser")
+		},
+		Output:  io.Discard,
+		Timeout: 100 * time.Millisecond,
+	})
+
+	_, err := c.GetJWT()
+
+	if err == nil {
+		t.Error("expected an error when the user doesn't log in")
+	}
+}
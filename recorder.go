@@ -0,0 +1,233 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Recorder Records every message on a set of subjects to a recording, so that
+// traffic can be replayed later using `Replay()`
+type Recorder struct {
+	subs []*nats.Subscription
+
+	mutex  sync.Mutex
+	writer *RecordingWriter
+	start  time.Time
+	count  int
+	limit  int
+	done   chan struct{}
+	err    error
+	closed bool
+}
+
+// RecorderOptions Controls what a recorder records
+type RecorderOptions struct {
+	// Stop recording after this many messages. Zero records until `Close()`
+	// is called
+	Limit int
+}
+
+// NewRecorder Subscribes to `subjects` on `nc` and records every message to
+// `w`. The recording is complete once `Close()` has been called
+func NewRecorder(nc *nats.Conn, w io.Writer, options RecorderOptions, subjects ...string) (*Recorder, error) {
+	if len(subjects) == 0 {
+		return nil, errors.New("no subjects to record")
+	}
+
+	writer, err := NewRecordingWriter(w)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := Recorder{
+		writer: writer,
+		start:  time.Now(),
+		limit:  options.Limit,
+		done:   make(chan struct{}),
+	}
+
+	for _, subject := range subjects {
+		sub, err := nc.Subscribe(subject, r.record)
+
+		if err != nil {
+			r.unsubscribe()
+			return nil, err
+		}
+
+		r.subs = append(r.subs, sub)
+	}
+
+	// Make sure that the server has the subscriptions before returning, so
+	// that nothing sent afterwards is missed
+	err = nc.Flush()
+
+	if err != nil {
+		r.unsubscribe()
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (r *Recorder) record(msg *nats.Msg) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed || r.err != nil || r.full() {
+		return
+	}
+
+	// The offset is taken while holding the lock so that messages from
+	// different subscriptions are written in order
+	r.err = r.writer.Write(RecordedMessage{
+		Offset:  time.Since(r.start),
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  msg.Header,
+		Data:    msg.Data,
+	})
+
+	if r.err != nil {
+		log.WithFields(log.Fields{
+			"error":   r.err,
+			"subject": msg.Subject,
+		}).Error("Recording message failed, recording stopped")
+
+		return
+	}
+
+	r.count++
+
+	if r.full() {
+		close(r.done)
+	}
+}
+
+// full Returns true once `limit` messages have been recorded
+func (r *Recorder) full() bool {
+	return r.limit > 0 && r.count >= r.limit
+}
+
+// Done Returns a channel that is closed once `Limit` messages have been
+// recorded. Messages after that are ignored
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Count Returns the number of messages recorded so far
+func (r *Recorder) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.count
+}
+
+// Close Stops recording and writes any buffered messages. Returns the first
+// error from writing the recording, if there was one. Closing a recorder that
+// is already closed does nothing
+func (r *Recorder) Close() error {
+	r.unsubscribe()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	if r.err != nil {
+		return r.err
+	}
+
+	return r.writer.Flush()
+}
+
+func (r *Recorder) unsubscribe() {
+	for _, sub := range r.subs {
+		sub.Unsubscribe()
+	}
+}
+
+// ReplayOptions Controls how a recording is replayed
+type ReplayOptions struct {
+	// How much faster than the original to replay messages, e.g. 2 replays
+	// twice as fast. 1 replays at the original speed, and zero replays as fast
+	// as possible
+	Speed float64
+	// Whether to keep the recorded reply subjects. These are usually inboxes
+	// of connections that no longer exist, so they are dropped by default
+	KeepReplies bool
+}
+
+// Replay Publishes the messages in a recording to `nc`, keeping the original
+// gaps between them divided by `Speed`. The first message is sent straight
+// away. Returns the number of messages that were published
+func Replay(ctx context.Context, nc *nats.Conn, r io.Reader, options ReplayOptions) (int, error) {
+	reader, err := NewRecordingReader(r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var start time.Time
+	var first time.Duration
+	var count int
+
+	for {
+		m, err := reader.Read()
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		if count == 0 {
+			start = time.Now()
+			first = m.Offset
+		}
+
+		if options.Speed > 0 {
+			due := start.Add(time.Duration(float64(m.Offset-first) / options.Speed))
+
+			err = sleepContext(ctx, time.Until(due))
+		} else {
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		msg := nats.Msg{
+			Subject: m.Subject,
+			Header:  m.Header,
+			Data:    m.Data,
+		}
+
+		if options.KeepReplies {
+			msg.Reply = m.Reply
+		}
+
+		err = nc.PublishMsg(&msg)
+
+		if err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nc.Flush()
+}
@@ -0,0 +1,233 @@
+package connect_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+// newTestRecorderConn Connects to a test server for recording and replaying
+func newTestRecorderConn(t *testing.T, s *connecttest.Server) *nats.Conn {
+	t.Helper()
+
+	o, err := s.NATSOptions(connecttest.UserConfig{Name: t.Name()})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := o.Connect()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(conn.Close)
+
+	return conn.Underlying()
+}
+
+func TestRecorder(t *testing.T) {
+	s := connecttest.Start(t)
+
+	nc := newTestRecorderConn(t, s)
+
+	var recording bytes.Buffer
+
+	recorder, err := connect.NewRecorder(nc, &recording, connect.RecorderOptions{}, "request.>", "query.*")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := newTestRecorderConn(t, s)
+
+	const gap = 100 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		err = publisher.PublishMsg(&nats.Msg{
+			Subject: fmt.Sprintf("request.scope.%v", i),
+			Header:  nats.Header{"Index": []string{fmt.Sprint(i)}},
+			Data:    []byte(fmt.Sprint(i)),
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Not recorded
+		err = publisher.Publish("other", []byte("ignored"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = publisher.Flush()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(gap)
+	}
+
+	// Wait for the recorder to receive everything
+	deadline := time.Now().Add(5 * time.Second)
+
+	for recorder.Count() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = recorder.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Count() != 5 {
+		t.Fatalf("expected 5 messages to be recorded, got %v", recorder.Count())
+	}
+
+	if err = recorder.Close(); err != nil {
+		t.Errorf("expected closing twice to do nothing, got %v", err)
+	}
+
+	// replay Replays the recording at `speed` and returns how long it took
+	// and the messages that were received
+	replay := func(t *testing.T, speed float64) (time.Duration, []*nats.Msg) {
+		t.Helper()
+
+		sub, err := nc.SubscribeSync("request.>")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer sub.Unsubscribe()
+
+		err = nc.Flush()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+
+		count, err := connect.Replay(context.Background(), publisher, bytes.NewReader(recording.Bytes()), connect.ReplayOptions{Speed: speed})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		elapsed := time.Since(start)
+
+		if count != 5 {
+			t.Errorf("expected 5 messages to be replayed, got %v", count)
+		}
+
+		var msgs []*nats.Msg
+
+		for i := 0; i < count; i++ {
+			msg, err := sub.NextMsg(5 * time.Second)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		return elapsed, msgs
+	}
+
+	t.Run("at the original speed", func(t *testing.T) {
+		elapsed, msgs := replay(t, 1)
+
+		// The first message is sent straight away, then there are four gaps
+		if elapsed < 4*gap {
+			t.Errorf("expected replaying to take at least %v, took %v", 4*gap, elapsed)
+		}
+
+		for i, msg := range msgs {
+			if msg.Subject != fmt.Sprintf("request.scope.%v", i) || string(msg.Data) != fmt.Sprint(i) {
+				t.Errorf("expected message %v in order, got %v: %v", i, msg.Subject, string(msg.Data))
+			}
+
+			if msg.Header.Get("Index") != fmt.Sprint(i) {
+				t.Errorf("expected headers to be replayed, got %v", msg.Header)
+			}
+		}
+	})
+
+	t.Run("accelerated", func(t *testing.T) {
+		elapsed, _ := replay(t, 10)
+
+		if elapsed > 2*gap {
+			t.Errorf("expected replaying ten times faster to take less than %v, took %v", 2*gap, elapsed)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := connect.Replay(ctx, publisher, bytes.NewReader(recording.Bytes()), connect.ReplayOptions{Speed: 1})
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestRecorderLimit(t *testing.T) {
+	s := connecttest.Start(t)
+
+	nc := newTestRecorderConn(t, s)
+
+	var recording bytes.Buffer
+
+	recorder, err := connect.NewRecorder(nc, &recording, connect.RecorderOptions{Limit: 2}, "request.>")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := newTestRecorderConn(t, s)
+
+	for i := 0; i < 5; i++ {
+		err = publisher.Publish("request.scope", []byte(fmt.Sprint(i)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = publisher.Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-recorder.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the recorder to finish")
+	}
+
+	err = recorder.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Count() != 2 {
+		t.Errorf("expected 2 messages to be recorded, got %v", recorder.Count())
+	}
+}
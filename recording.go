@@ -0,0 +1,256 @@
+package connect
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// recordingMagic Identifies a recording file and the version of its format
+const recordingMagic = "NATSREC\x01"
+
+// recordingMaxField The largest field that will be read from a recording, so
+// that a corrupt length can't cause a huge allocation
+const recordingMaxField = 64 * 1024 * 1024
+
+// ErrInvalidRecording Returned when reading a file that isn't a recording, or
+// that is corrupt
+var ErrInvalidRecording = errors.New("invalid recording")
+
+// RecordedMessage A message in a recording
+type RecordedMessage struct {
+	// When the message was received, relative to the start of the recording
+	Offset time.Duration
+	// The subject that the message was published to
+	Subject string
+	// The reply subject, if any
+	Reply string
+	// The headers, if any
+	Header nats.Header
+	// The payload
+	Data []byte
+}
+
+// RecordingWriter Writes messages to a recording. The format is a magic
+// string followed by one record per message. Each record is the offset from
+// the previous message in microseconds, the subject, reply, headers and data,
+// all as varints and length prefixed strings, so recordings have very little
+// overhead beyond the messages themselves
+type RecordingWriter struct {
+	w          *bufio.Writer
+	lastOffset time.Duration
+	buf        []byte
+}
+
+// NewRecordingWriter Starts a recording by writing the file header to `w`.
+// Messages are buffered, so `Flush()` must be called once they have all been
+// written
+func NewRecordingWriter(w io.Writer) (*RecordingWriter, error) {
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.WriteString(recordingMagic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecordingWriter{w: bw}, nil
+}
+
+// Write Writes a message to the recording. Messages must be written in order
+// of their offset
+func (r *RecordingWriter) Write(m RecordedMessage) error {
+	if m.Offset < r.lastOffset {
+		return fmt.Errorf("message offset %v is before the previous message's %v", m.Offset, r.lastOffset)
+	}
+
+	buf := r.buf[:0]
+	buf = binary.AppendUvarint(buf, uint64((m.Offset - r.lastOffset).Microseconds()))
+	buf = appendString(buf, m.Subject)
+	buf = appendString(buf, m.Reply)
+	buf = binary.AppendUvarint(buf, uint64(len(m.Header)))
+
+	for key, values := range m.Header {
+		buf = appendString(buf, key)
+		buf = binary.AppendUvarint(buf, uint64(len(values)))
+
+		for _, value := range values {
+			buf = appendString(buf, value)
+		}
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(m.Data)))
+	buf = append(buf, m.Data...)
+
+	// Keep the buffer for the next message
+	r.buf = buf
+
+	_, err := r.w.Write(buf)
+
+	if err != nil {
+		return err
+	}
+
+	// Round in the same way as the stored delta, so that offsets don't drift
+	r.lastOffset += (m.Offset - r.lastOffset).Truncate(time.Microsecond)
+
+	return nil
+}
+
+// Flush Writes any buffered messages
+func (r *RecordingWriter) Flush() error {
+	return r.w.Flush()
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// RecordingReader Reads messages from a recording written by
+// `RecordingWriter`
+type RecordingReader struct {
+	r      *bufio.Reader
+	offset time.Duration
+}
+
+// NewRecordingReader Reads the file header from `r`, returning
+// `ErrInvalidRecording` if it isn't a recording
+func NewRecordingReader(r io.Reader) (*RecordingReader, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(recordingMagic))
+
+	_, err := io.ReadFull(br, magic)
+
+	if err != nil || string(magic) != recordingMagic {
+		return nil, ErrInvalidRecording
+	}
+
+	return &RecordingReader{r: br}, nil
+}
+
+// Read Returns the next message, or `io.EOF` once all of them have been read
+func (r *RecordingReader) Read() (RecordedMessage, error) {
+	delta, err := binary.ReadUvarint(r.r)
+
+	if err != nil {
+		// A clean end of the file can only happen between messages
+		if errors.Is(err, io.EOF) {
+			return RecordedMessage{}, io.EOF
+		}
+
+		return RecordedMessage{}, invalidRecording(err)
+	}
+
+	r.offset += time.Duration(delta) * time.Microsecond
+
+	m := RecordedMessage{Offset: r.offset}
+
+	m.Subject, err = r.readString()
+
+	if err != nil {
+		return RecordedMessage{}, err
+	}
+
+	m.Reply, err = r.readString()
+
+	if err != nil {
+		return RecordedMessage{}, err
+	}
+
+	headers, err := r.readLength()
+
+	if err != nil {
+		return RecordedMessage{}, err
+	}
+
+	if headers > 0 {
+		// The count comes from the file, so don't size the map from it
+		m.Header = nats.Header{}
+	}
+
+	for i := 0; i < headers; i++ {
+		key, err := r.readString()
+
+		if err != nil {
+			return RecordedMessage{}, err
+		}
+
+		values, err := r.readLength()
+
+		if err != nil {
+			return RecordedMessage{}, err
+		}
+
+		for j := 0; j < values; j++ {
+			value, err := r.readString()
+
+			if err != nil {
+				return RecordedMessage{}, err
+			}
+
+			m.Header[key] = append(m.Header[key], value)
+		}
+	}
+
+	m.Data, err = r.readBytes()
+
+	if err != nil {
+		return RecordedMessage{}, err
+	}
+
+	return m, nil
+}
+
+func (r *RecordingReader) readLength() (int, error) {
+	n, err := binary.ReadUvarint(r.r)
+
+	if err != nil {
+		return 0, invalidRecording(err)
+	}
+
+	if n > recordingMaxField {
+		return 0, invalidRecording(fmt.Errorf("field length %v is too large", n))
+	}
+
+	return int(n), nil
+}
+
+func (r *RecordingReader) readBytes() ([]byte, error) {
+	n, err := r.readLength()
+
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, n)
+
+	_, err = io.ReadFull(r.r, b)
+
+	if err != nil {
+		return nil, invalidRecording(err)
+	}
+
+	return b, nil
+}
+
+func (r *RecordingReader) readString() (string, error) {
+	b, err := r.readBytes()
+
+	return string(b), err
+}
+
+// invalidRecording Wraps an error from reading part way through a message
+func invalidRecording(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return fmt.Errorf("%w: %v", ErrInvalidRecording, err)
+}
@@ -0,0 +1,165 @@
+package connect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	messages := []RecordedMessage{
+		{
+			Offset:  0,
+			Subject: "request.all",
+			Data:    []byte("first"),
+		},
+		{
+			Offset:  1500 * time.Microsecond,
+			Subject: "query.1234",
+			Reply:   "_INBOX.abc",
+			Header: nats.Header{
+				"Traceparent": []string{"00-abc-def-01"},
+				"Multi":       []string{"one", "two"},
+			},
+			Data: []byte{0x00, 0xff},
+		},
+		{
+			Offset:  2 * time.Second,
+			Subject: "empty",
+		},
+	}
+
+	var buf bytes.Buffer
+
+	w, err := NewRecordingWriter(&buf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range messages {
+		err = w.Write(m)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = w.Flush()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload int
+
+	for _, m := range messages {
+		payload += len(m.Subject) + len(m.Data)
+	}
+
+	// The format should add little beyond the messages and headers
+	if overhead := buf.Len() - payload; overhead > 100 {
+		t.Errorf("expected a compact recording, got %v bytes of overhead", overhead)
+	}
+
+	r, err := NewRecordingReader(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range messages {
+		m, err := r.Read()
+
+		if err != nil {
+			t.Fatalf("reading message %v failed: %v", i, err)
+		}
+
+		if m.Offset != expected.Offset || m.Subject != expected.Subject || m.Reply != expected.Reply || !bytes.Equal(m.Data, expected.Data) {
+			t.Errorf("expected message %v to be %+v, got %+v", i, expected, m)
+		}
+
+		for key, values := range expected.Header {
+			if got := m.Header.Values(key); len(got) != len(values) {
+				t.Errorf("expected header %v to be %v, got %v", key, values, got)
+			}
+		}
+	}
+
+	_, err = r.Read()
+
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF at the end, got %v", err)
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		r, err := NewRecordingReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for err == nil {
+			_, err = r.Read()
+		}
+
+		if !errors.Is(err, ErrInvalidRecording) {
+			t.Errorf("expected ErrInvalidRecording, got %v", err)
+		}
+	})
+}
+
+func TestRecordingErrors(t *testing.T) {
+	t.Run("not a recording", func(t *testing.T) {
+		_, err := NewRecordingReader(bytes.NewReader([]byte("hello world")))
+
+		if !errors.Is(err, ErrInvalidRecording) {
+			t.Errorf("expected ErrInvalidRecording, got %v", err)
+		}
+	})
+
+	t.Run("corrupt header count", func(t *testing.T) {
+		b := []byte(recordingMagic)
+		b = binary.AppendUvarint(b, 0)
+		b = appendString(b, "a")
+		b = appendString(b, "")
+		b = binary.AppendUvarint(b, recordingMaxField)
+
+		r, err := NewRecordingReader(bytes.NewReader(b))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = r.Read()
+
+		if !errors.Is(err, ErrInvalidRecording) {
+			t.Errorf("expected ErrInvalidRecording, got %v", err)
+		}
+	})
+
+	t.Run("messages out of order", func(t *testing.T) {
+		w, err := NewRecordingWriter(io.Discard)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Write(RecordedMessage{Offset: time.Second, Subject: "a"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Write(RecordedMessage{Offset: time.Millisecond, Subject: "b"})
+
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrRefreshTokenRevoked Returned when the authorization server rejects the
+// refresh token. This happens when the token has been revoked or has expired,
+// or when the server has detected that a rotated refresh token was reused, in
+// which case it will have revoked every token issued from it. The user will
+// need to log in again
+var ErrRefreshTokenRevoked = errors.New("refresh token is invalid or has been revoked")
+
+// RefreshTokenConfig Authenticates to Overmind by redeeming an OAuth refresh
+// token, for example one that was issued when the user logged in using
+// `DeviceFlowTokenClient` or `AuthCodeTokenClient`
+type RefreshTokenConfig struct {
+	// The ClientID of the application that issued the refresh token
+	ClientID string
+	// The ClientSecret for the application. Leave empty for public clients
+	ClientSecret string
+	// The token endpoint e.g. https://auth.overmind.tech/oauth/token
+	TokenURL string
+	// The refresh token to redeem
+	RefreshToken string
+	// Scopes to request. If omitted, the scopes of the original token are used
+	Scopes []string
+	// The account to request a NATS token for. This has the same meaning as
+	// `ClientCredentialsConfig.Account`
+	Account string
+	// Called with the new refresh token whenever the authorization server
+	// rotates it. The old refresh token won't be accepted again, so anything
+	// that has stored it should replace it with this one
+	OnRotate func(refreshToken string)
+	// The token revocation endpoint (RFC 7009). If set, the refresh token is
+	// revoked when the client is closed
+	RevocationURL string
+}
+
+// RefreshTokenClient Gets a NATS token by redeeming an OAuth refresh token for
+// an access token, then using that token to retrieve a NATS token in the same
+// way as `OAuthTokenClient`
+type RefreshTokenClient struct {
+	*OAuthTokenClient
+
+	source *refreshTokenSource
+}
+
+// NewRefreshTokenClient Generates a token client that redeems a refresh token
+// whenever it needs a new access token. `overmindAPIURL` is the root URL of the
+// NATS token exchange API e.g. https://api.server.test/v1
+func NewRefreshTokenClient(overmindAPIURL string, flowConfig RefreshTokenConfig) *RefreshTokenClient {
+	ts := &refreshTokenSource{
+		config: &oauth2.Config{
+			ClientID:     flowConfig.ClientID,
+			ClientSecret: flowConfig.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				TokenURL: flowConfig.TokenURL,
+			},
+			Scopes: flowConfig.Scopes,
+		},
+		refreshToken:  flowConfig.RefreshToken,
+		onRotate:      flowConfig.OnRotate,
+		revocationURL: flowConfig.RevocationURL,
+	}
+
+	return &RefreshTokenClient{
+		OAuthTokenClient: NewOAuthTokenClientWithTokenSource(overmindAPIURL, flowConfig.Account, ts),
+		source:           ts,
+	}
+}
+
+// RefreshToken Returns the current refresh token. This will differ from the
+// one that the client was created with if it has been rotated
+func (r *RefreshTokenClient) RefreshToken() string {
+	r.source.mutex.Lock()
+	defer r.source.mutex.Unlock()
+
+	return r.source.refreshToken
+}
+
+// refreshTokenSource An `oauth2.TokenSource` that redeems a refresh token,
+// keeping track of the new refresh token when it is rotated
+type refreshTokenSource struct {
+	config        *oauth2.Config
+	onRotate      func(refreshToken string)
+	revocationURL string
+
+	// The mutex also ensures that the refresh token is only redeemed once at a
+	// time, since redeeming the same token twice would look like reuse
+	mutex        sync.Mutex
+	refreshToken string
+	token        *oauth2.Token
+	// When the current token was fetched
+	fetched time.Time
+}
+
+func (r *refreshTokenSource) Token() (*oauth2.Token, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.token != nil && !expiresWithinMargin(r.fetched, r.token.Expiry) {
+		return r.token, nil
+	}
+
+	if r.refreshToken == "" {
+		return nil, ErrRefreshTokenRevoked
+	}
+
+	token, err := r.config.TokenSource(context.Background(), &oauth2.Token{
+		RefreshToken: r.refreshToken,
+	}).Token()
+
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			// Forget the refresh token so that we don't keep sending a token
+			// that is known to be bad
+			r.refreshToken = ""
+			r.token = nil
+
+			return nil, fmt.Errorf("%w: %v", ErrRefreshTokenRevoked, err)
+		}
+
+		return nil, err
+	}
+
+	if token.RefreshToken != "" && token.RefreshToken != r.refreshToken {
+		r.refreshToken = token.RefreshToken
+
+		if r.onRotate != nil {
+			r.onRotate(token.RefreshToken)
+		}
+	}
+
+	r.token = token
+	r.fetched = time.Now()
+
+	return token, nil
+}
+
+// Close Revokes the refresh token if there is a revocation endpoint, and
+// forgets it either way so that it can't be redeemed again
+func (r *refreshTokenSource) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	refreshToken := r.refreshToken
+
+	r.refreshToken = ""
+	r.token = nil
+
+	if r.revocationURL == "" || refreshToken == "" {
+		return nil
+	}
+
+	return revokeOAuthToken(r.revocationURL, r.config.ClientID, r.config.ClientSecret, &oauth2.Token{
+		RefreshToken: refreshToken,
+	})
+}
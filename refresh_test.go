@@ -0,0 +1,174 @@
+package connect_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestRefreshTokenClient(t *testing.T) {
+	newClient := func(t *testing.T, api *connecttest.APIServer, onRotate func(string)) (*connect.RefreshTokenClient, string) {
+		t.Helper()
+
+		refreshToken, err := api.IssueRefreshToken()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return connect.NewRefreshTokenClient(api.ExchangeURL, connect.RefreshTokenConfig{
+			ClientID:     connecttest.ClientID,
+			TokenURL:     api.OAuthURL,
+			RefreshToken: refreshToken,
+			OnRotate:     onRotate,
+		}), refreshToken
+	}
+
+	t.Run("rotating the refresh token", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+
+		var rotated []string
+
+		c, original := newClient(t, api, func(refreshToken string) {
+			rotated = append(rotated, refreshToken)
+		})
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rotated) != 1 || rotated[0] == original {
+			t.Errorf("expected refresh token to be rotated once, got %v", rotated)
+		}
+
+		if len(rotated) > 0 && c.RefreshToken() != rotated[0] {
+			t.Errorf("expected current refresh token to be %v, got %v", rotated[0], c.RefreshToken())
+		}
+
+		// The access token is still valid so it shouldn't be refreshed again
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if requests := len(api.Requests(connecttest.EndpointOAuth)); requests != 1 {
+			t.Errorf("expected 1 request to the token endpoint, got %v", requests)
+		}
+	})
+
+	t.Run("with an access token that is about to expire", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+
+		var rotations int
+
+		c, _ := newClient(t, api, func(string) { rotations++ })
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.ExpireAccessToken(5 * time.Second)
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if requests := len(api.Requests(connecttest.EndpointOAuth)); requests != 2 {
+			t.Errorf("expected the access token to be refreshed, got %v requests", requests)
+		}
+
+		if rotations != 2 {
+			t.Errorf("expected refresh token to have been rotated twice, got %v", rotations)
+		}
+	})
+
+	t.Run("with a JWT that is about to expire", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+		c, _ := newClient(t, api, nil)
+
+		token, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := jwt.DecodeUserClaims(token)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pair, err := nkeys.CreateAccount()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The expiry margin is capped at half the token's lifetime, so the
+		// token has to have been issued a while ago
+		claims.Expires = time.Now().Add(3 * time.Second).Unix()
+		expiringToken := encodeIssuedAt(t, claims, pair, time.Now().Add(-10*time.Second))
+
+		c.SetJWT(expiringToken)
+
+		newToken, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if newToken == expiringToken {
+			t.Error("expected token to be replaced before it expired")
+		}
+	})
+
+	t.Run("with a reused refresh token", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+		first, refreshToken := newClient(t, api, nil)
+
+		second := connect.NewRefreshTokenClient(api.ExchangeURL, connect.RefreshTokenConfig{
+			ClientID:     connecttest.ClientID,
+			TokenURL:     api.OAuthURL,
+			RefreshToken: refreshToken,
+		})
+
+		_, err := first.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The second client tries to use the same refresh token, which should
+		// be detected as reuse
+		_, err = second.GetJWT()
+
+		if !errors.Is(err, connect.ErrRefreshTokenRevoked) {
+			t.Fatalf("expected ErrRefreshTokenRevoked, got %v", err)
+		}
+
+		requests := len(api.Requests(connecttest.EndpointOAuth))
+
+		// The client should not keep sending a token it knows is bad
+		_, err = second.GetJWT()
+
+		if !errors.Is(err, connect.ErrRefreshTokenRevoked) {
+			t.Errorf("expected ErrRefreshTokenRevoked, got %v", err)
+		}
+
+		if len(api.Requests(connecttest.EndpointOAuth)) != requests {
+			t.Error("expected no further requests after the refresh token was rejected")
+		}
+	})
+}
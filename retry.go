@@ -0,0 +1,219 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Retry defaults
+const RetryMaxAttemptsDefault = 3
+const RetryInitialBackoffDefault = 500 * time.Millisecond
+const RetryMaxBackoffDefault = 10 * time.Second
+
+// Circuit breaker defaults
+const BreakerThresholdDefault = 5
+const BreakerCooldownDefault = 30 * time.Second
+
+// ErrCircuitOpen Returned when a request wasn't attempted because the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open, the token exchange API is unavailable")
+
+// RetryPolicy Controls how requests to the token exchange API are retried
+// when they fail with a 429 or 5xx response, or don't get a response at all.
+// Other errors are not retried. Zero values are replaced with the defaults
+type RetryPolicy struct {
+	// The total number of attempts, including the first one
+	MaxAttempts int
+	// The delay before the first retry. This doubles for each subsequent retry
+	InitialBackoff time.Duration
+	// The maximum delay between attempts. If the server asks us to wait longer
+	// than this using `Retry-After`, we give up instead
+	MaxBackoff time.Duration
+}
+
+func (r RetryPolicy) maxAttempts() int {
+	if r.MaxAttempts > 0 {
+		return r.MaxAttempts
+	}
+
+	return RetryMaxAttemptsDefault
+}
+
+func (r RetryPolicy) maxBackoff() time.Duration {
+	if r.MaxBackoff > 0 {
+		return r.MaxBackoff
+	}
+
+	return RetryMaxBackoffDefault
+}
+
+// backoff Returns how long to wait after the supplied attempt has failed
+// (starting at 1). The delay grows exponentially and half of it is randomised
+// so that clients which failed at the same time don't retry at the same time
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	delay := r.InitialBackoff
+
+	if delay <= 0 {
+		delay = RetryInitialBackoffDefault
+	}
+
+	for i := 1; i < attempt && delay < r.maxBackoff(); i++ {
+		delay *= 2
+	}
+
+	if delay > r.maxBackoff() {
+		delay = r.maxBackoff()
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryable Returns true if a request that failed with the supplied response
+// is worth retrying. A nil response means that the server couldn't be reached
+func retryable(response *http.Response) bool {
+	if response == nil {
+		return true
+	}
+
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+}
+
+// retryAfter Parses the `Retry-After` header, which can be either a number of
+// seconds or a date. Returns false if the header is missing or invalid
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	if response == nil {
+		return 0, false
+	}
+
+	value := response.Header.Get("Retry-After")
+
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+
+		if delay < 0 {
+			delay = 0
+		}
+
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// sleepContext Waits for the supplied duration, returning early with an error
+// if the context is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// CircuitBreaker Stops requests from being made to the token exchange API
+// while it is unhealthy. After `Threshold` consecutive failures the breaker
+// opens and requests fail immediately with `ErrCircuitOpen`. Once `Cooldown`
+// has passed a single request is allowed through; if it succeeds the breaker
+// closes, otherwise it stays open for another cooldown. Zero values are
+// replaced with the defaults
+type CircuitBreaker struct {
+	// How many consecutive failures open the breaker
+	Threshold int
+	// How long the breaker stays open before trying again
+	Cooldown time.Duration
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	trying   bool
+}
+
+// Open Returns true if the breaker is currently open
+func (c *CircuitBreaker) Open() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return !c.openedAt.IsZero()
+}
+
+// allow Returns `ErrCircuitOpen` if a request shouldn't be made. When the
+// cooldown has passed only one caller is allowed to try
+func (c *CircuitBreaker) allow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.openedAt.IsZero() {
+		return nil
+	}
+
+	cooldown := c.Cooldown
+
+	if cooldown <= 0 {
+		cooldown = BreakerCooldownDefault
+	}
+
+	if c.trying || time.Since(c.openedAt) < cooldown {
+		return ErrCircuitOpen
+	}
+
+	c.trying = true
+
+	return nil
+}
+
+// success Records a successful request, closing the breaker
+func (c *CircuitBreaker) success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.failures = 0
+	c.openedAt = time.Time{}
+	c.trying = false
+}
+
+// cancelled Records a request that was abandoned because the caller's context
+// was done. This isn't counted as a failure, but lets another caller try if
+// this was the request allowed through after the cooldown
+func (c *CircuitBreaker) cancelled() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trying = false
+}
+
+// failure Records a failed request, opening the breaker if there have been
+// too many in a row
+func (c *CircuitBreaker) failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	threshold := c.Threshold
+
+	if threshold <= 0 {
+		threshold = BreakerThresholdDefault
+	}
+
+	c.failures++
+	c.trying = false
+
+	if c.failures >= threshold || !c.openedAt.IsZero() {
+		c.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,319 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+// newRetryClient Creates a token client with short backoffs, and the API that
+// it uses
+func newRetryClient(t *testing.T) (*connect.OAuthTokenClient, *connecttest.APIServer) {
+	t.Helper()
+
+	api := connecttest.StartAPI(t, nil)
+
+	c := api.NewTokenClient("")
+	c.Retry = connect.RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+	}
+
+	return c, api
+}
+
+// createTokenRequests Returns the number of requests for NATS JWTs
+func createTokenRequests(api *connecttest.APIServer) int {
+	return len(api.Requests(connecttest.EndpointCreateToken))
+}
+
+func TestOAuthTokenClientRetries(t *testing.T) {
+	t.Run("with transient failures", func(t *testing.T) {
+		c, api := newRetryClient(t)
+
+		api.Fail(connecttest.EndpointCreateToken, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if requests := createTokenRequests(api); requests != 3 {
+			t.Errorf("expected 3 requests, got %v", requests)
+		}
+	})
+
+	t.Run("with too many failures", func(t *testing.T) {
+		c, api := newRetryClient(t)
+
+		api.Fail(connecttest.EndpointCreateToken, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+		_, err := c.GetJWT()
+
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if requests := createTokenRequests(api); requests != connect.RetryMaxAttemptsDefault {
+			t.Errorf("expected %v requests, got %v", connect.RetryMaxAttemptsDefault, requests)
+		}
+	})
+
+	t.Run("with a client error", func(t *testing.T) {
+		c, api := newRetryClient(t)
+
+		api.Fail(connecttest.EndpointCreateToken, http.StatusBadRequest)
+
+		_, err := c.GetJWT()
+
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if requests := createTokenRequests(api); requests != 1 {
+			t.Errorf("expected client errors not to be retried, got %v requests", requests)
+		}
+	})
+
+	t.Run("with Retry-After", func(t *testing.T) {
+		c, api := newRetryClient(t)
+
+		api.Script(connecttest.EndpointCreateToken, connecttest.Failure{
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: time.Second,
+		})
+
+		start := time.Now()
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if time.Since(start) < time.Second {
+			t.Errorf("expected to wait for Retry-After, took %v", time.Since(start))
+		}
+	})
+
+	t.Run("with Retry-After longer than the maximum backoff", func(t *testing.T) {
+		c, api := newRetryClient(t)
+
+		api.Script(connecttest.EndpointCreateToken, connecttest.Failure{
+			StatusCode: http.StatusTooManyRequests,
+			RetryAfter: time.Minute,
+		})
+
+		_, err := c.GetJWT()
+
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		if requests := createTokenRequests(api); requests != 1 {
+			t.Errorf("expected 1 request, got %v", requests)
+		}
+	})
+}
+
+func TestOAuthTokenClientCircuitBreaker(t *testing.T) {
+	c, api := newRetryClient(t)
+	c.Retry.MaxAttempts = 1
+	c.Breaker.Threshold = 1
+	c.Breaker.Cooldown = time.Hour
+
+	token, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the JWT with one that is about to expire
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountKeys, err := nkeys.CreateAccount()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiry margin is capped at half the token's lifetime, so the token
+	// has to have been issued a while ago
+	claims.Expires = time.Now().Add(3 * time.Second).Unix()
+	expiringToken := encodeIssuedAt(t, claims, accountKeys, time.Now().Add(-10*time.Second))
+
+	c.SetJWT(expiringToken)
+
+	api.Fail(connecttest.EndpointCreateToken, http.StatusBadGateway, http.StatusBadGateway)
+	before := createTokenRequests(api)
+
+	// The refresh fails and opens the breaker, so the current token is used
+	token, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != expiringToken {
+		t.Error("expected the current token to be used")
+	}
+
+	if !c.Breaker.Open() {
+		t.Error("expected the breaker to be open")
+	}
+
+	// While it is open no requests are made
+	_, err = c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests := createTokenRequests(api) - before; requests != 1 {
+		t.Errorf("expected 1 request, got %v", requests)
+	}
+
+	// Once the token has expired there is nothing to fall back on
+	claims.Expires = time.Now().Add(-10 * time.Second).Unix()
+	expiredToken, err := claims.Encode(accountKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetJWT(expiredToken)
+
+	_, err = c.GetJWT()
+
+	if !errors.Is(err, connect.ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+func TestOAuthTokenClientGetJWTContext(t *testing.T) {
+	c, api := newRetryClient(t)
+	c.Retry = connect.RetryPolicy{
+		InitialBackoff: 10 * time.Second,
+		MaxBackoff:     10 * time.Second,
+	}
+
+	api.Fail(connecttest.EndpointCreateToken, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := c.GetJWTContext(ctx)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected retries to stop when the context expired, took %v", time.Since(start))
+	}
+
+	t.Run("doesn't open the breaker", func(t *testing.T) {
+		c, api := newRetryClient(t)
+		c.Breaker.Threshold = 1
+
+		// The caller gives up during the backoff, and then during a request
+		api.Fail(connecttest.EndpointCreateToken, http.StatusServiceUnavailable)
+		c.Retry.InitialBackoff = 10 * time.Second
+		c.Retry.MaxBackoff = 10 * time.Second
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err := c.GetJWTContext(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+
+		api.Script(connecttest.EndpointCreateToken, connecttest.Failure{Latency: time.Second})
+
+		ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err = c.GetJWTContext(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+
+		if c.Breaker.Open() {
+			t.Error("expected the breaker to stay closed when the caller gives up")
+		}
+	})
+}
+
+func TestOAuthTokenClientConcurrentGetJWT(t *testing.T) {
+	c, api := newRetryClient(t)
+
+	api.Script(connecttest.EndpointCreateToken, connecttest.Failure{Latency: 500 * time.Millisecond})
+
+	tokens := make(chan string, 3)
+	errs := make(chan error, 3)
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			token, err := c.GetJWT()
+
+			tokens <- token
+			errs <- err
+		}()
+	}
+
+	// Give the slow request time to start
+	time.Sleep(100 * time.Millisecond)
+
+	// Signing isn't blocked while the JWT is being fetched
+	start := time.Now()
+
+	_, err := c.Sign([]byte("nonce"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("expected signing not to wait for the JWT, took %v", elapsed)
+	}
+
+	var first string
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+
+		token := <-tokens
+
+		if first == "" {
+			first = token
+		}
+
+		if token != first {
+			t.Error("expected every caller to get the same JWT")
+		}
+	}
+
+	// Only one of the callers gets a new JWT
+	if requests := createTokenRequests(api); requests != 1 {
+		t.Errorf("expected 1 request, got %v", requests)
+	}
+}
@@ -0,0 +1,99 @@
+package connect
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	header := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	if d, ok := retryAfter(header("3")); !ok || d != 3*time.Second {
+		t.Errorf("expected 3s, got %v %v", d, ok)
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+
+	if d, ok := retryAfter(header(date)); !ok || d < 58*time.Second || d > time.Minute {
+		t.Errorf("expected about a minute, got %v %v", d, ok)
+	}
+
+	if _, ok := retryAfter(header("soon")); ok {
+		t.Error("expected an invalid header to be ignored")
+	}
+
+	if _, ok := retryAfter(&http.Response{}); ok {
+		t.Error("expected a missing header to be ignored")
+	}
+
+	if _, ok := retryAfter(nil); ok {
+		t.Error("expected a missing response to be ignored")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	r := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		d := r.backoff(attempt)
+
+		if d < max/2 || d > max {
+			t.Errorf("expected backoff for attempt %v to be between %v and %v, got %v", attempt, max/2, max, d)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	c := CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  50 * time.Millisecond,
+	}
+
+	c.failure()
+
+	if c.Open() || c.allow() != nil {
+		t.Fatal("expected breaker to be closed after one failure")
+	}
+
+	c.failure()
+
+	if !c.Open() || !errors.Is(c.allow(), ErrCircuitOpen) {
+		t.Fatal("expected breaker to be open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if c.allow() != nil {
+		t.Fatal("expected a request to be allowed after the cooldown")
+	}
+
+	if !errors.Is(c.allow(), ErrCircuitOpen) {
+		t.Error("expected only one request to be allowed after the cooldown")
+	}
+
+	// A failed trial re-opens the breaker straight away
+	c.failure()
+
+	if !errors.Is(c.allow(), ErrCircuitOpen) {
+		t.Error("expected breaker to re-open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if c.allow() != nil {
+		t.Fatal("expected a request to be allowed after the cooldown")
+	}
+
+	c.success()
+
+	if c.Open() || c.allow() != nil {
+		t.Error("expected breaker to be closed")
+	}
+}
@@ -0,0 +1,266 @@
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	log "github.com/sirupsen/logrus"
+)
+
+// SignerTimeoutDefault How long to wait for an external signer to respond
+const SignerTimeoutDefault = 5 * time.Second
+
+// Signer Holds the NKey that a NATS connection authenticates with. This
+// separates custody of the key from getting tokens, so that the key can be
+// held by another process. Note that `nkeys.KeyPair` implements this interface
+type Signer interface {
+	// PublicKey Returns the public key, which is what tokens are issued for
+	PublicKey() (string, error)
+
+	// Sign Signs some binary data using the private key
+	Sign([]byte) ([]byte, error)
+}
+
+// LocalSigner A signer that holds its NKey in memory
+type LocalSigner struct {
+	keys nkeys.KeyPair
+}
+
+// NewLocalSigner Creates a signer with a newly generated user NKey
+func NewLocalSigner() (*LocalSigner, error) {
+	keys, err := nkeys.CreateUser()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalSigner{
+		keys: keys,
+	}, nil
+}
+
+// NewLocalSignerFromSeed Creates a signer from an existing user NKey seed
+func NewLocalSignerFromSeed(seed []byte) (*LocalSigner, error) {
+	keys, err := nkeys.FromSeed(seed)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalSigner{
+		keys: keys,
+	}, nil
+}
+
+func (l *LocalSigner) PublicKey() (string, error) {
+	return l.keys.PublicKey()
+}
+
+func (l *LocalSigner) Sign(in []byte) ([]byte, error) {
+	return l.keys.Sign(in)
+}
+
+// Wipe Removes the NKey from memory. The signer can't be used afterwards
+func (l *LocalSigner) Wipe() {
+	l.keys.Wipe()
+}
+
+// signerRequest A request to an external signer. Each request is a single
+// line of JSON
+type signerRequest struct {
+	// Either "public_key" or "sign"
+	Method string `json:"method"`
+	// The data to sign
+	Data []byte `json:"data,omitempty"`
+}
+
+// signerResponse The response from an external signer
+type signerResponse struct {
+	PublicKey string `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SocketSigner A signer that asks an external process to sign on its behalf,
+// so that the private key is never held by this process. The external process
+// is reached using a stream socket, usually a Unix socket, and can be served
+// using `ServeSigner`
+type SocketSigner struct {
+	// How long to wait for the signer to respond. Defaults to
+	// `SignerTimeoutDefault`
+	Timeout time.Duration
+
+	network string
+	address string
+
+	mutex     sync.Mutex
+	publicKey string
+}
+
+// NewSocketSigner Creates a signer that connects to an external signer at
+// `address` e.g. `NewSocketSigner("unix", "/run/signer.sock")`
+func NewSocketSigner(network string, address string) *SocketSigner {
+	return &SocketSigner{
+		network: network,
+		address: address,
+	}
+}
+
+// PublicKey Returns the public key of the external signer. Since this can't
+// change it is only requested once
+func (s *SocketSigner) PublicKey() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.publicKey != "" {
+		return s.publicKey, nil
+	}
+
+	response, err := s.call(context.Background(), signerRequest{Method: "public_key"})
+
+	if err != nil {
+		return "", err
+	}
+
+	s.publicKey = response.PublicKey
+
+	return s.publicKey, nil
+}
+
+func (s *SocketSigner) Sign(in []byte) ([]byte, error) {
+	return s.SignContext(context.Background(), in)
+}
+
+// SignContext Asks the external signer to sign, giving up if `ctx` is
+// cancelled or its deadline passes first
+func (s *SocketSigner) SignContext(ctx context.Context, in []byte) ([]byte, error) {
+	response, err := s.call(ctx, signerRequest{
+		Method: "sign",
+		Data:   in,
+	})
+
+	if err != nil {
+		return []byte{}, err
+	}
+
+	return response.Signature, nil
+}
+
+// call Sends a single request to the external signer
+func (s *SocketSigner) call(ctx context.Context, request signerRequest) (*signerResponse, error) {
+	timeout := s.Timeout
+
+	if timeout == 0 {
+		timeout = SignerTimeoutDefault
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+
+	if err != nil {
+		return nil, fmt.Errorf("connecting to signer failed: %w", err)
+	}
+
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+
+	err = conn.SetDeadline(deadline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.NewEncoder(conn).Encode(request)
+
+	if err != nil {
+		return nil, fmt.Errorf("sending request to signer failed: %w", err)
+	}
+
+	var response signerResponse
+
+	err = json.NewDecoder(conn).Decode(&response)
+
+	if err != nil {
+		return nil, fmt.Errorf("reading response from signer failed: %w", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("signer returned an error: %v", response.Error)
+	}
+
+	return &response, nil
+}
+
+// ServeSigner Answers requests from `SocketSigner` using the supplied signer,
+// until the listener is closed. This can be used to build an external signer
+// process, or with a `LocalSigner` as a stand-in for one in development and
+// tests
+func ServeSigner(listener net.Listener, signer Signer) error {
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		go serveSignerConn(conn, signer)
+	}
+}
+
+// serveSignerConn Answers requests on a single connection until it is closed
+func serveSignerConn(conn net.Conn, signer Signer) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+
+	for {
+		var request signerRequest
+
+		err := decoder.Decode(&request)
+
+		if err != nil {
+			return
+		}
+
+		var response signerResponse
+
+		switch request.Method {
+		case "public_key":
+			response.PublicKey, err = signer.PublicKey()
+		case "sign":
+			response.Signature, err = signer.Sign(request.Data)
+		default:
+			err = fmt.Errorf("unknown method %v", request.Method)
+		}
+
+		if err != nil {
+			response.Error = err.Error()
+		}
+
+		err = encoder.Encode(response)
+
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Could not send response to signer client")
+
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package connect_test
+
+import (
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestOAuthTokenClientSigner(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+	signer, pubKey := connect.NewTestSocketSigner(t)
+
+	c := api.NewTokenClient("")
+	c.Signer = signer
+
+	token, err := c.GetJWT()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != pubKey {
+		t.Errorf("expected token to be issued for the signer's key %v, got %v", pubKey, claims.Subject)
+	}
+
+	if c.Signer != signer {
+		t.Error("expected the supplied signer to be kept")
+	}
+}
@@ -0,0 +1,113 @@
+package connect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+)
+
+// newTestSocketSigner Serves a local signer on a Unix socket and returns a
+// signer that uses it, along with the local signer's public key
+func newTestSocketSigner(t *testing.T) (*SocketSigner, string) {
+	t.Helper()
+
+	local, err := NewLocalSigner()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey, err := local.PublicKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "signer.sock")
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go ServeSigner(listener, local)
+
+	return NewSocketSigner("unix", path), pubKey
+}
+
+func TestSocketSigner(t *testing.T) {
+	signer, expectedPubKey := newTestSocketSigner(t)
+
+	pubKey, err := signer.PublicKey()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pubKey != expectedPubKey {
+		t.Errorf("expected public key %v, got %v", expectedPubKey, pubKey)
+	}
+
+	data := []byte{1, 156, 230, 4, 23, 175, 11}
+
+	signed, err := signer.Sign(data)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := nkeys.FromPublicKey(pubKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = verifier.Verify(data, signed)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	t.Run("with an unknown method", func(t *testing.T) {
+		_, err := signer.call(context.Background(), signerRequest{Method: "seed"})
+
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("with a cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := signer.SignContext(ctx, data)
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("with no signer running", func(t *testing.T) {
+		s := NewSocketSigner("unix", filepath.Join(t.TempDir(), "missing.sock"))
+
+		signed, err := s.Sign(data)
+
+		if err == nil {
+			t.Error("expected an error")
+		}
+
+		// Matches the token clients, which return an empty signature on error
+		if signed == nil || len(signed) != 0 {
+			t.Errorf("expected an empty signature, got %v", signed)
+		}
+	})
+}
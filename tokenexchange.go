@@ -0,0 +1,266 @@
+package connect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Token types defined in RFC 8693. These can be used as the
+// `SubjectTokenType` in `TokenExchangeConfig`
+const (
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+	TokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// tokenExchangeGrantType The grant type for RFC 8693 token exchange
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// TokenExchangeConfig Authenticates to Overmind by exchanging an identity token
+// that the workload already has, such as a Kubernetes projected service account
+// token or a CI OIDC token, for an access token using OAuth 2.0 Token Exchange
+// (RFC 8693). This means that no client secret needs to be stored
+// https://www.rfc-editor.org/rfc/rfc8693
+type TokenExchangeConfig struct {
+	// The token endpoint that supports token exchange
+	TokenURL string
+	// The ClientID to authenticate as, if the server requires it
+	ClientID string
+	// The ClientSecret for the ClientID, if the server requires it
+	ClientSecret string
+	// Path to a file containing the subject token. This is read again for
+	// every exchange, since tokens such as Kubernetes projected service account
+	// tokens are rotated by writing a new file
+	SubjectTokenFile string
+	// Returns the subject token. Use this instead of `SubjectTokenFile` when the
+	// token isn't available as a file
+	SubjectTokenFunc func(ctx context.Context) (string, error)
+	// The type of the subject token. Defaults to `TokenTypeJWT`
+	SubjectTokenType string
+	// The audience to request a token for. Defaults to `DefaultAudience`
+	Audience string
+	// Scopes to request
+	Scopes []string
+	// The account to request a NATS token for. This has the same meaning as
+	// `ClientCredentialsConfig.Account`
+	Account string
+}
+
+// TokenExchangeTokenClient Gets a NATS token by exchanging a workload identity
+// token for an access token, then using that token to retrieve a NATS token in
+// the same way as `OAuthTokenClient`
+type TokenExchangeTokenClient struct {
+	*OAuthTokenClient
+
+	source *tokenExchangeSource
+}
+
+// NewTokenExchangeTokenClient Generates a token client that uses token exchange
+// whenever it needs a new access token. One of `SubjectTokenFile` or
+// `SubjectTokenFunc` must be set. `overmindAPIURL` is the root URL of the NATS
+// token exchange API e.g. https://api.server.test/v1
+func NewTokenExchangeTokenClient(overmindAPIURL string, flowConfig TokenExchangeConfig) *TokenExchangeTokenClient {
+	ts := &tokenExchangeSource{
+		config: flowConfig,
+	}
+
+	if ts.config.SubjectTokenType == "" {
+		ts.config.SubjectTokenType = TokenTypeJWT
+	}
+
+	if ts.config.Audience == "" {
+		ts.config.Audience = DefaultAudience
+	}
+
+	return &TokenExchangeTokenClient{
+		OAuthTokenClient: NewOAuthTokenClientWithTokenSource(overmindAPIURL, flowConfig.Account, ts),
+		source:           ts,
+	}
+}
+
+// tokenExchangeSource An `oauth2.TokenSource` that uses token exchange to get
+// access tokens
+type tokenExchangeSource struct {
+	config TokenExchangeConfig
+
+	mutex sync.Mutex
+	token *oauth2.Token
+	// When the current token was fetched
+	fetched time.Time
+}
+
+// tokenExchangeResponse The successful response from a token exchange request
+// https://www.rfc-editor.org/rfc/rfc8693#section-2.2.1
+type tokenExchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// tokenErrorResponse An error response from an OAuth token endpoint
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.2
+type tokenErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	ErrorURI         string `json:"error_uri"`
+}
+
+func (s *tokenExchangeSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != nil && !expiresWithinMargin(s.fetched, s.token.Expiry) {
+		return s.token, nil
+	}
+
+	ctx := context.Background()
+
+	subjectToken, err := s.subjectToken(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.exchange(ctx, subjectToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.token = token
+	s.fetched = time.Now()
+
+	return token, nil
+}
+
+// subjectToken Reads the current subject token from the file or callback
+func (s *tokenExchangeSource) subjectToken(ctx context.Context) (string, error) {
+	var subjectToken string
+
+	switch {
+	case s.config.SubjectTokenFunc != nil:
+		token, err := s.config.SubjectTokenFunc(ctx)
+
+		if err != nil {
+			return "", fmt.Errorf("getting subject token failed: %w", err)
+		}
+
+		subjectToken = token
+	case s.config.SubjectTokenFile != "":
+		b, err := os.ReadFile(s.config.SubjectTokenFile)
+
+		if err != nil {
+			return "", fmt.Errorf("reading subject token failed: %w", err)
+		}
+
+		subjectToken = string(b)
+	default:
+		return "", errors.New("token exchange requires either SubjectTokenFile or SubjectTokenFunc")
+	}
+
+	subjectToken = strings.TrimSpace(subjectToken)
+
+	if subjectToken == "" {
+		return "", errors.New("subject token is empty")
+	}
+
+	return subjectToken, nil
+}
+
+// exchange Exchanges the subject token for an access token
+func (s *tokenExchangeSource) exchange(ctx context.Context, subjectToken string) (*oauth2.Token, error) {
+	values := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {s.config.SubjectTokenType},
+		"requested_token_type": {TokenTypeAccessToken},
+		"audience":             {s.config.Audience},
+	}
+
+	if len(s.config.Scopes) > 0 {
+		values.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+
+	if s.config.ClientID != "" && s.config.ClientSecret == "" {
+		values.Set("client_id", s.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(values.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if s.config.ClientID != "" && s.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("token exchange request failed: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+
+	if err != nil {
+		return nil, fmt.Errorf("reading token exchange response failed: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		retrieveErr := &oauth2.RetrieveError{
+			Response: res,
+			Body:     body,
+		}
+
+		var errorResponse tokenErrorResponse
+
+		if json.Unmarshal(body, &errorResponse) == nil {
+			retrieveErr.ErrorCode = errorResponse.Error
+			retrieveErr.ErrorDescription = errorResponse.ErrorDescription
+			retrieveErr.ErrorURI = errorResponse.ErrorURI
+		}
+
+		return nil, retrieveErr
+	}
+
+	var response tokenExchangeResponse
+
+	err = json.Unmarshal(body, &response)
+
+	if err != nil {
+		return nil, fmt.Errorf("parsing token exchange response failed: %w", err)
+	}
+
+	if response.AccessToken == "" {
+		return nil, errors.New("token exchange response did not contain an access token")
+	}
+
+	// The token type can be "N_A" for token exchange, but we will always be
+	// using the token as a bearer token
+	token := &oauth2.Token{
+		AccessToken: response.AccessToken,
+		TokenType:   "Bearer",
+	}
+
+	if response.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,149 @@
+package connect_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+	"golang.org/x/oauth2"
+)
+
+// exchangedSubjects Returns the subject tokens that have been exchanged, after
+// checking that each was exchanged for the default audience
+func exchangedSubjects(t *testing.T, api *connecttest.APIServer) []string {
+	t.Helper()
+
+	subjects := make([]string, 0)
+
+	for _, r := range api.Requests(connecttest.EndpointOAuth) {
+		if r.Form.Get("subject_token_type") != connect.TokenTypeJWT || r.Form.Get("audience") != connect.DefaultAudience {
+			t.Errorf("unexpected token exchange request %v", r.Form)
+		}
+
+		subjects = append(subjects, r.Form.Get("subject_token"))
+	}
+
+	return subjects
+}
+
+func TestTokenExchangeTokenClient(t *testing.T) {
+	t.Run("with a subject token file", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+
+		tokenFile := filepath.Join(t.TempDir(), "token")
+
+		err := os.WriteFile(tokenFile, []byte("subject-one\n"), 0600)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := connect.NewTokenExchangeTokenClient(api.ExchangeURL, connect.TokenExchangeConfig{
+			TokenURL:         api.OAuthURL,
+			SubjectTokenFile: tokenFile,
+		})
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if subjects := exchangedSubjects(t, api); len(subjects) != 1 || subjects[0] != "subject-one" {
+			t.Errorf("expected subject-one to be exchanged, got %v", subjects)
+		}
+
+		// Rotate the token on disk like the kubelet would, and expire the
+		// access token
+		err = os.WriteFile(tokenFile, []byte("subject-two\n"), 0600)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.ExpireAccessToken(-time.Second)
+
+		_, err = c.OAuthToken()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if subjects := exchangedSubjects(t, api); len(subjects) != 2 || subjects[1] != "subject-two" {
+			t.Errorf("expected the rotated token subject-two to be exchanged, got %v", subjects)
+		}
+	})
+
+	t.Run("with a subject token callback", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+
+		var mutex sync.Mutex
+		var calls int
+
+		c := connect.NewTokenExchangeTokenClient(api.ExchangeURL, connect.TokenExchangeConfig{
+			TokenURL: api.OAuthURL,
+			SubjectTokenFunc: func(ctx context.Context) (string, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				calls++
+
+				return "subject-callback", nil
+			},
+		})
+
+		_, err := c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.GetJWT()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if subjects := exchangedSubjects(t, api); len(subjects) != 1 || subjects[0] != "subject-callback" {
+			t.Errorf("expected subject-callback to be exchanged once, got %v", subjects)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if calls != 1 {
+			t.Errorf("expected the access token to be cached, but subject token was read %v times", calls)
+		}
+	})
+
+	t.Run("with an untrusted subject token", func(t *testing.T) {
+		api := connecttest.StartAPI(t, nil)
+		api.Fail(connecttest.EndpointOAuth, http.StatusBadRequest)
+
+		c := connect.NewTokenExchangeTokenClient(api.ExchangeURL, connect.TokenExchangeConfig{
+			TokenURL: api.OAuthURL,
+			SubjectTokenFunc: func(ctx context.Context) (string, error) {
+				return "untrusted", nil
+			},
+		})
+
+		_, err := c.GetJWT()
+
+		var retrieveErr *oauth2.RetrieveError
+
+		if !errors.As(err, &retrieveErr) {
+			t.Fatalf("expected a RetrieveError, got %v", err)
+		}
+
+		if retrieveErr.ErrorCode != "invalid_grant" {
+			t.Errorf("expected invalid_grant error, got %v", retrieveErr.ErrorCode)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package connect
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// ErrSubjectMismatch Returned when a JWT was issued for a different NKey to
+// the one that the client is using
+var ErrSubjectMismatch = errors.New("JWT subject does not match NKey public key")
+
+// ErrUntrustedIssuer Returned when a JWT wasn't issued by a trusted account
+var ErrUntrustedIssuer = errors.New("JWT was not issued by a trusted account")
+
+// JWTVerifier Verifies NATS user JWTs offline against pinned keys, so that a
+// compromised or misrouted token API can't hand us a token issued by someone
+// else. A token is trusted if its account is in `TrustedAccounts`, or if the
+// account's JWT is in `AccountJWTs` and was issued by one of
+// `TrustedOperators`. If the token was signed using an account signing key
+// rather than the account key itself, the account JWT is required to check
+// that the signing key belongs to the account
+type JWTVerifier struct {
+	// Public keys of accounts that are trusted to issue user JWTs
+	TrustedAccounts []string
+	// Public keys of operators, or operator signing keys, that are trusted to
+	// issue account JWTs
+	TrustedOperators []string
+	// Account JWTs, used to check signing keys and to verify accounts against
+	// `TrustedOperators`
+	AccountJWTs []string
+}
+
+// Verify Checks the signature of a user JWT, that it was issued for
+// `subject`, and that it was issued by a trusted account. Returns the decoded
+// claims if it is trusted
+func (v *JWTVerifier) Verify(token string, subject string) (*jwt.UserClaims, error) {
+	claims, err := verifySubject(token, subject)
+
+	if err != nil {
+		return nil, err
+	}
+
+	account := claims.IssuerAccount
+
+	if account == "" {
+		account = claims.Issuer
+	}
+
+	accountClaims, err := v.accountClaims(account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != account {
+		// The token was signed with a signing key, which we can only trust if
+		// the account says it is one of its own
+		if accountClaims == nil {
+			return nil, fmt.Errorf("%w: issuer %v is a signing key for account %v, but the account JWT is not available", ErrUntrustedIssuer, claims.Issuer, account)
+		}
+
+		if !accountClaims.SigningKeys.Contains(claims.Issuer) {
+			return nil, fmt.Errorf("%w: issuer %v is not a signing key for account %v", ErrUntrustedIssuer, claims.Issuer, account)
+		}
+	}
+
+	if contains(v.TrustedAccounts, account) {
+		return claims, nil
+	}
+
+	if accountClaims != nil && contains(v.TrustedOperators, accountClaims.Issuer) {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("%w: account %v", ErrUntrustedIssuer, account)
+}
+
+// verifySubject Decodes a user JWT, which checks that it was signed by its
+// issuer, and checks that it was issued for `subject`
+func verifySubject(token string, subject string) (*jwt.UserClaims, error) {
+	claims, err := jwt.DecodeUserClaims(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject != subject {
+		return nil, fmt.Errorf("%w: expected %v, got %v", ErrSubjectMismatch, subject, claims.Subject)
+	}
+
+	return claims, nil
+}
+
+// accountClaims Returns the decoded claims from the JWT for the supplied
+// account, or nil if there isn't one
+func (v *JWTVerifier) accountClaims(account string) (*jwt.AccountClaims, error) {
+	for _, token := range v.AccountJWTs {
+		claims, err := jwt.DecodeAccountClaims(token)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid account JWT: %w", err)
+		}
+
+		if claims.Subject == account {
+			return claims, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package connect_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/overmindtech/connect"
+	"github.com/overmindtech/connect/connecttest"
+)
+
+func TestOAuthTokenClientVerifier(t *testing.T) {
+	api := connecttest.StartAPI(t, nil)
+
+	trustedKeys, err := nkeys.CreateAccount()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustedPubKey, _ := trustedKeys.PublicKey()
+
+	c := api.NewTokenClient("")
+	c.Verifier = &connect.JWTVerifier{
+		TrustedAccounts: []string{trustedPubKey},
+	}
+
+	// The fake API signs with its own account, which isn't trusted
+	_, err = c.GetJWT()
+
+	if !errors.Is(err, connect.ErrUntrustedIssuer) {
+		t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+	}
+
+	if c.CachedJWT() != "" {
+		t.Error("expected untrusted JWT not to be stored")
+	}
+}
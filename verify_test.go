@@ -0,0 +1,133 @@
+package connect
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+func TestJWTVerifier(t *testing.T) {
+	newKeys := func(create func() (nkeys.KeyPair, error)) (nkeys.KeyPair, string) {
+		keys, err := create()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubKey, _ := keys.PublicKey()
+
+		return keys, pubKey
+	}
+
+	operatorKeys, operatorPubKey := newKeys(nkeys.CreateOperator)
+	accountKeys, accountPubKey := newKeys(nkeys.CreateAccount)
+	signingKeys, signingPubKey := newKeys(nkeys.CreateAccount)
+	_, userPubKey := newKeys(nkeys.CreateUser)
+	_, otherPubKey := newKeys(nkeys.CreateUser)
+
+	accountClaims := jwt.NewAccountClaims(accountPubKey)
+	accountClaims.SigningKeys.Add(signingPubKey)
+
+	accountJWT, err := accountClaims.Encode(operatorKeys)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userJWT := func(subject string, signer nkeys.KeyPair, issuerAccount string) string {
+		claims := jwt.NewUserClaims(subject)
+		claims.IssuerAccount = issuerAccount
+
+		token, err := claims.Encode(signer)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token
+	}
+
+	t.Run("with a trusted account", func(t *testing.T) {
+		v := JWTVerifier{TrustedAccounts: []string{accountPubKey}}
+
+		_, err := v.Verify(userJWT(userPubKey, accountKeys, ""), userPubKey)
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("with an untrusted account", func(t *testing.T) {
+		otherAccountKeys, _ := newKeys(nkeys.CreateAccount)
+		v := JWTVerifier{TrustedAccounts: []string{accountPubKey}}
+
+		_, err := v.Verify(userJWT(userPubKey, otherAccountKeys, ""), userPubKey)
+
+		if !errors.Is(err, ErrUntrustedIssuer) {
+			t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+		}
+	})
+
+	t.Run("with the wrong subject", func(t *testing.T) {
+		v := JWTVerifier{TrustedAccounts: []string{accountPubKey}}
+
+		_, err := v.Verify(userJWT(otherPubKey, accountKeys, ""), userPubKey)
+
+		if !errors.Is(err, ErrSubjectMismatch) {
+			t.Errorf("expected ErrSubjectMismatch, got %v", err)
+		}
+	})
+
+	t.Run("with a signing key and a trusted operator", func(t *testing.T) {
+		v := JWTVerifier{
+			TrustedOperators: []string{operatorPubKey},
+			AccountJWTs:      []string{accountJWT},
+		}
+
+		_, err := v.Verify(userJWT(userPubKey, signingKeys, accountPubKey), userPubKey)
+
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("with an untrusted operator", func(t *testing.T) {
+		_, otherOperatorPubKey := newKeys(nkeys.CreateOperator)
+		v := JWTVerifier{
+			TrustedOperators: []string{otherOperatorPubKey},
+			AccountJWTs:      []string{accountJWT},
+		}
+
+		_, err := v.Verify(userJWT(userPubKey, accountKeys, ""), userPubKey)
+
+		if !errors.Is(err, ErrUntrustedIssuer) {
+			t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+		}
+	})
+
+	t.Run("with an unknown signing key", func(t *testing.T) {
+		otherSigningKeys, _ := newKeys(nkeys.CreateAccount)
+		v := JWTVerifier{
+			TrustedAccounts: []string{accountPubKey},
+			AccountJWTs:     []string{accountJWT},
+		}
+
+		_, err := v.Verify(userJWT(userPubKey, otherSigningKeys, accountPubKey), userPubKey)
+
+		if !errors.Is(err, ErrUntrustedIssuer) {
+			t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+		}
+	})
+
+	t.Run("with a signing key but no account JWT", func(t *testing.T) {
+		v := JWTVerifier{TrustedAccounts: []string{accountPubKey}}
+
+		_, err := v.Verify(userJWT(userPubKey, signingKeys, accountPubKey), userPubKey)
+
+		if !errors.Is(err, ErrUntrustedIssuer) {
+			t.Errorf("expected ErrUntrustedIssuer, got %v", err)
+		}
+	})
+}